@@ -0,0 +1,232 @@
+package car
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+
+	"github.com/minio/sha256-simd"
+)
+
+// ArchiveAttribute prefixes the attribute that records the name of the archive holding an entry built by
+// Builder.BuildArchive, e.g. "archive=incoming.tar.gz".
+const ArchiveAttribute = "archive="
+
+var (
+	ErrArchiveCheckpoint  = errors.New("checkpoints are not supported for archives")
+	ErrInvalidArchivePath = errors.New("archive member path is not a valid relative path")
+)
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+// archiveMember is a regular file in an archive, whose content can be read from r until the next member is read.
+type archiveMember struct {
+	header *tar.Header
+	info   fs.FileInfo
+	name   string
+	r      io.Reader
+}
+
+// BuildArchive pages every regular file in the tar, gzip-compressed tar or zip archive at path, which is detected from
+// the content of the file rather than its name. Members are hashed as they are read from the archive, which is never
+// extracted.
+//
+// Entry paths are recorded relative to the root of the archive, and the archive's file name is recorded in the
+// entry's attributes using ArchiveAttribute. Checkpoints are not supported for archives, so ErrArchiveCheckpoint is
+// returned if the Builder has a checkpoint file.
+func (b *Builder) BuildArchive(ctx context.Context, path string) ([]*Manifest, error) {
+	if b.Checkpoint != "" {
+		return nil, fmt.Errorf("car_builder: %w: %s", ErrArchiveCheckpoint, b.Checkpoint)
+	}
+
+	archive := filepath.Base(path)
+	for member, err := range archiveMembers(path) {
+		if err != nil {
+			return nil, fmt.Errorf("car_builder: %s: %w", archive, err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("car_builder: %w", err)
+		}
+
+		f, err := archiveFile(archive, member)
+		if err != nil {
+			return nil, fmt.Errorf("car_builder: %s: %s: %w", archive, member.name, err)
+		}
+
+		if err := b.Add(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Manifests(), nil
+}
+
+// ArchiveOf returns the name of the archive recorded in the entry's attributes by Builder.BuildArchive, or the empty
+// string if the entry was not read from an archive.
+func ArchiveOf(f *cadre.File) string {
+	for _, a := range f.Attributes {
+		if name, ok := strings.CutPrefix(a, ArchiveAttribute); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// archiveMembers returns an iterator over the regular files in the archive at path.
+func archiveMembers(path string) iter.Seq2[archiveMember, error] {
+	return func(yield func(archiveMember, error) bool) {
+		f, err := os.Open(path)
+		if err != nil {
+			yield(archiveMember{}, err)
+			return
+		}
+		defer func(f *os.File) {
+			if err := f.Close(); err != nil {
+				fmt.Println(fmt.Errorf("car_builder: %w", err))
+			}
+		}(f)
+
+		br := bufio.NewReaderSize(f, tokenBufferSize)
+		magic, err := br.Peek(len(zipMagic))
+		if err != nil && !errors.Is(err, io.EOF) {
+			yield(archiveMember{}, err)
+			return
+		}
+
+		switch {
+		case bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, zipEmptyMagic):
+			info, err := f.Stat()
+			if err != nil {
+				yield(archiveMember{}, err)
+				return
+			}
+			zipMembers(f, info.Size(), yield)
+		case bytes.HasPrefix(magic, gzipMagic):
+			gr, err := gzip.NewReader(br)
+			if err != nil {
+				yield(archiveMember{}, err)
+				return
+			}
+			tarMembers(gr, yield)
+		default:
+			tarMembers(br, yield)
+		}
+	}
+}
+
+func tarMembers(r io.Reader, yield func(archiveMember, error) bool) {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		if err != nil {
+			yield(archiveMember{}, err)
+			return
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !yield(archiveMember{header: h, info: h.FileInfo(), name: h.Name, r: tr}, nil) {
+			return
+		}
+	}
+}
+
+func zipMembers(r io.ReaderAt, size int64, yield func(archiveMember, error) bool) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		yield(archiveMember{}, err)
+		return
+	}
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			yield(archiveMember{}, err)
+			return
+		}
+
+		ok := yield(archiveMember{info: zf.FileInfo(), name: zf.Name, r: rc}, nil)
+		if err := rc.Close(); err != nil {
+			fmt.Println(fmt.Errorf("car_builder: %w", err))
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// archiveFile creates a cadre.File for the archive member, hashing its content.
+func archiveFile(archive string, member archiveMember) (*cadre.File, error) {
+	name := entryName(member.name)
+	if !fs.ValidPath(name) || name == "." {
+		return nil, ErrInvalidArchivePath
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, member.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n != member.info.Size() {
+		return nil, fmt.Errorf("%w: read %d bytes, expected %d", ErrSizeMismatch, n, member.info.Size())
+	}
+
+	mtime := member.info.ModTime()
+	f := &cadre.File{
+		Attributes: []string{ArchiveAttribute + archive},
+		Directory:  path.Dir(name),
+		Extension:  path.Ext(name),
+		Hash:       &ecs.Hash{Sha256: hex.EncodeToString(h.Sum(nil))},
+		MimeType:   mime.TypeByExtension(path.Ext(name)),
+		Mode:       strconv.Itoa(int(member.info.Mode())),
+		Mtime:      &mtime,
+		Name:       path.Base(name),
+		Path:       name,
+		Size:       n,
+		Type:       "file",
+	}
+
+	if hdr := member.header; hdr != nil {
+		f.UID, f.GID = strconv.Itoa(hdr.Uid), strconv.Itoa(hdr.Gid)
+		f.Owner, f.Group = hdr.Uname, hdr.Gname
+	}
+	return f, nil
+}
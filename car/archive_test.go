@@ -0,0 +1,82 @@
+package car
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTar(t *testing.T, p string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, name := range sortedPaths(files) {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "incoming.tar")
+	writeTestTar(t, archive, map[string]string{"a.txt": "a", "sub/b.txt": "bb"})
+
+	b := NewBuilder("ns", filepath.Join(dir, "out"))
+	pages, err := b.BuildArchive(context.Background(), archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 1 || pages[0].Count() != 2 || pages[0].Size() != 3 {
+		t.Fatalf("unexpected pages: %v", pages)
+	}
+
+	for entry, err := range pages[0].Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ArchiveOf(entry) != "incoming.tar" {
+			t.Errorf("%s: archive %q, expected incoming.tar", entry.Path, ArchiveOf(entry))
+		}
+	}
+}
+
+func TestBuildArchiveCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "incoming.tar")
+	writeTestTar(t, archive, map[string]string{"a.txt": "a"})
+
+	b := NewBuilder("ns", filepath.Join(dir, "out"))
+	b.Checkpoint = filepath.Join(dir, "checkpoint.json")
+	if _, err := b.BuildArchive(context.Background(), archive); !errors.Is(err, ErrArchiveCheckpoint) {
+		t.Fatalf("expected %v, got %v", ErrArchiveCheckpoint, err)
+	}
+
+	if _, err := os.Stat(b.Checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("checkpoint file was written: %v", err)
+	}
+
+	if len(b.Manifests()) != 0 {
+		t.Fatalf("pages were built: %d", len(b.Manifests()))
+	}
+}
@@ -0,0 +1,263 @@
+package car
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+
+	"github.com/minio/sha256-simd"
+)
+
+// Builder walks a directory tree and pages the files it finds into Manifest pages.
+//
+// A new page is cut once adding the next file would exceed MaxSize bytes or once the current page holds MaxEntries
+// entries. A zero value for either threshold disables it. When Output is set, each completed page is written to it
+// using Manifest.WriteTo. Columns lists the columns from EntriesCSVExtraFields written to each page's entries.csv.
+//
+// When Dedup is set, a file whose sha256 digest matches a file that is already archived, either by an earlier entry or
+// by a page the Deduplicator was seeded with, is added to the current page as a Reference instead of an entry.
+//
+// When Checkpoint is set, BuildFS records its progress in the checkpoint file whenever a page is completed and every
+// CheckpointInterval files, and resumes from the checkpoint file if it exists, so that an interrupted build produces the
+// same pages as an uninterrupted one. The checkpoint file is removed once the build completes. Checkpoints require
+// Output, from which completed pages are read back on resume.
+type Builder struct {
+	Checkpoint         string
+	CheckpointInterval int
+	Columns            []string
+	Dedup              *Deduplicator
+	Namespace          string
+	Index              uint
+	MaxEntries         int
+	MaxSize            int64
+	Output             string
+
+	files    int
+	lastPath string
+	page     *Manifest
+	pages    []*Manifest
+}
+
+// NewBuilder creates a new Builder for the provided namespace that writes completed pages to output.
+func NewBuilder(namespace string, output string) *Builder {
+	return &Builder{
+		Namespace: namespace,
+		Output:    output,
+	}
+}
+
+// Add adds the provided files to the current page, cutting a new page whenever a threshold is reached.
+func (b *Builder) Add(files ...*cadre.File) error {
+	for _, f := range files {
+		if b.Dedup != nil {
+			if r, ok := b.Dedup.Lookup(f); ok {
+				if err := b.startPage(); err != nil {
+					return err
+				}
+				b.page.AddReference(r)
+				continue
+			}
+		}
+
+		if b.page != nil && b.full(f) {
+			if err := b.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if err := b.startPage(); err != nil {
+			return err
+		}
+		b.page.Add(f)
+
+		if b.Dedup != nil {
+			b.Dedup.Record(b.page.Index(), f)
+		}
+	}
+	return nil
+}
+
+// Build walks the directory tree rooted at root and pages every regular file it contains.
+func (b *Builder) Build(ctx context.Context, root string) ([]*Manifest, error) {
+	return b.BuildFS(ctx, os.DirFS(root))
+}
+
+// BuildFS walks fsys and pages every regular file it contains. Entry paths are recorded relative to the root of fsys.
+func (b *Builder) BuildFS(ctx context.Context, fsys fs.FS) ([]*Manifest, error) {
+	if b.Checkpoint != "" {
+		if b.Output == "" {
+			return nil, fmt.Errorf("car_builder: checkpoint %s requires an output directory", b.Checkpoint)
+		}
+
+		if err := b.resume(); err != nil {
+			return nil, fmt.Errorf("car_builder: %w", err)
+		}
+	}
+
+	resume := b.lastPath
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if resume != "" && name != "." {
+			if d.IsDir() && walkBefore(name, resume) && !strings.HasPrefix(resume, name+"/") {
+				return fs.SkipDir
+			}
+
+			if !walkBefore(resume, name) {
+				return nil
+			}
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := statFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Add(f); err != nil {
+			return err
+		}
+		b.lastPath = name
+		b.files++
+
+		interval := b.CheckpointInterval
+		if interval <= 0 {
+			interval = DefaultCheckpointInterval
+		}
+
+		if b.Checkpoint != "" && b.files%interval == 0 {
+			return b.checkpoint()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("car_builder: %w", err)
+	}
+
+	if err := b.Flush(); err != nil {
+		return nil, err
+	}
+
+	if b.Checkpoint != "" {
+		if err := os.Remove(b.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("car_builder: %w", err)
+		}
+	}
+	return b.Manifests(), nil
+}
+
+// Flush completes the current page, writing it to Output if set. Flush is a no-op if the current page is empty.
+func (b *Builder) Flush() error {
+	if b.page == nil {
+		return nil
+	}
+
+	if b.Output != "" {
+		if err := b.page.WriteTo(b.Output); err != nil {
+			return fmt.Errorf("car_builder: %w", err)
+		}
+	}
+	b.pages = append(b.pages, b.page)
+	b.page = nil
+
+	if b.Checkpoint != "" {
+		if err := b.checkpoint(); err != nil {
+			return fmt.Errorf("car_builder: %w", err)
+		}
+	}
+	return nil
+}
+
+// Manifests returns the pages completed by the Builder.
+func (b *Builder) Manifests() []*Manifest {
+	return b.pages
+}
+
+// startPage starts a new page if there is no current page.
+func (b *Builder) startPage() error {
+	if b.page != nil {
+		return nil
+	}
+
+	page := NewManifest(b.Namespace, b.Index+uint(len(b.pages)))
+	if err := page.SetColumns(b.Columns...); err != nil {
+		return err
+	}
+	b.page = page
+	return nil
+}
+
+func (b *Builder) full(f *cadre.File) bool {
+	if b.MaxEntries > 0 && b.page.Count() >= b.MaxEntries {
+		return true
+	}
+	return b.MaxSize > 0 && b.page.Size()+f.Size > b.MaxSize
+}
+
+// statFile creates a cadre.File for the named regular file in fsys, including its sha256 digest and mtime.
+func statFile(fsys fs.FS, name string) (*cadre.File, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := hashFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	mtime := info.ModTime()
+	f := &cadre.File{
+		Directory: path.Dir(name),
+		Extension: path.Ext(name),
+		Hash:      &ecs.Hash{Sha256: digest},
+		MimeType:  mime.TypeByExtension(path.Ext(name)),
+		Mode:      strconv.Itoa(int(info.Mode())),
+		Mtime:     &mtime,
+		Name:      info.Name(),
+		Path:      name,
+		Size:      info.Size(),
+		Type:      "file",
+	}
+	statOwner(f, info)
+	return f, nil
+}
+
+// hashFile returns the hex-encoded sha256 digest of the named file in fsys.
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func(f fs.File) {
+		if err := f.Close(); err != nil {
+			fmt.Println(fmt.Errorf("car_builder: %w", err))
+		}
+	}(f)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,726 @@
+package car
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// CarFileExtension is the file extension used for CAR files.
+	CarFileExtension = ".car"
+
+	carV2HeaderSize = 40
+
+	// carMaxHeaderSize and carMaxSectionSize bound the allocations made for a CARv1 header and a block section, so that
+	// a corrupt length prefix cannot exhaust memory.
+	carMaxHeaderSize  = 32 << 20
+	carMaxSectionSize = 32 << 20
+
+	// indexMultihashSorted is the multicodec for the car-multihash-index-sorted CARv2 index format.
+	indexMultihashSorted = 0x0401
+)
+
+var (
+	ErrInvalidCar            = errors.New("invalid CAR file")
+	ErrUnsupportedCarVersion = errors.New("unsupported CAR version")
+)
+
+// carV2Pragma is the fixed prefix of a CARv2 file: a CARv1 style header declaring version 2.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+type carIndexRecord struct {
+	code   uint64
+	digest []byte
+	offset uint64
+}
+
+// carFileWriter writes blocks to a CARv1 or CARv2 file.
+//
+// The CARv1 header is written up front with a placeholder root of the same encoded length as the final root, and is
+// rewritten with the actual root once all blocks have been written. For CARv2, a car-multihash-index-sorted index is
+// appended after the data payload.
+type carFileWriter struct {
+	file    *os.File
+	writer  *bufio.Writer
+	version int
+	offset  uint64
+	index   []carIndexRecord
+	seen    map[string]bool
+}
+
+func newCarFileWriter(path string, version int, placeholder cid.Cid) (*carFileWriter, error) {
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCarVersion, version)
+	}
+
+	file, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &carFileWriter{
+		file:    file,
+		writer:  bufio.NewWriterSize(file, tokenBufferSize),
+		version: version,
+		seen:    make(map[string]bool),
+	}
+
+	if version == 2 {
+		if _, err := w.writer.Write(carV2Pragma); err != nil {
+			return nil, w.abort(err)
+		}
+
+		if _, err := w.writer.Write(make([]byte, carV2HeaderSize)); err != nil {
+			return nil, w.abort(err)
+		}
+	}
+
+	header := carV1Header(placeholder)
+	if _, err := w.writer.Write(header); err != nil {
+		return nil, w.abort(err)
+	}
+	w.offset = uint64(len(header))
+	return w, nil
+}
+
+// put writes a block section to the payload. Blocks that have already been written are skipped.
+func (w *carFileWriter) put(c cid.Cid, data []byte) error {
+	key := c.KeyString()
+	if w.seen[key] {
+		return nil
+	}
+	w.seen[key] = true
+
+	cb := c.Bytes()
+	section := binary.AppendUvarint(nil, uint64(len(cb)+len(data)))
+	if w.version == 2 {
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return err
+		}
+
+		if dmh.Code != multihash.IDENTITY {
+			w.index = append(w.index, carIndexRecord{code: dmh.Code, digest: dmh.Digest, offset: w.offset})
+		}
+	}
+
+	for _, b := range [][]byte{section, cb, data} {
+		if _, err := w.writer.Write(b); err != nil {
+			return err
+		}
+		w.offset += uint64(len(b))
+	}
+	return nil
+}
+
+// finish rewrites the header with the provided root, writes the index for CARv2, and closes the file.
+func (w *carFileWriter) finish(root cid.Cid) error {
+	dataOffset := int64(0)
+	if w.version == 2 {
+		dataOffset = int64(len(carV2Pragma) + carV2HeaderSize)
+		if err := writeMultihashIndex(w.writer, w.index); err != nil {
+			return w.abort(err)
+		}
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return w.abort(err)
+	}
+
+	if _, err := w.file.WriteAt(carV1Header(root), dataOffset); err != nil {
+		return w.abort(err)
+	}
+
+	if w.version == 2 {
+		header := make([]byte, carV2HeaderSize)
+		binary.LittleEndian.PutUint64(header[16:], uint64(dataOffset))
+		binary.LittleEndian.PutUint64(header[24:], w.offset)
+		binary.LittleEndian.PutUint64(header[32:], uint64(dataOffset)+w.offset)
+		if _, err := w.file.WriteAt(header, int64(len(carV2Pragma))); err != nil {
+			return w.abort(err)
+		}
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return w.abort(err)
+	}
+	return w.file.Close()
+}
+
+// abort closes and removes the partially written file, returning the provided error.
+func (w *carFileWriter) abort(err error) error {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+	return err
+}
+
+// carBlock is a block section read from a CAR file. Offset is the position of the block data within the file, and data
+// is only valid until the next block has been read.
+type carBlock struct {
+	cid    cid.Cid
+	data   []byte
+	offset int64
+}
+
+// carFileReader reads the roots and block sections of a CARv1 or CARv2 file.
+type carFileReader struct {
+	file        *os.File
+	version     int
+	roots       []cid.Cid
+	blockOffset int64
+	dataOffset  int64
+	dataEnd     int64
+	indexOffset int64
+}
+
+func openCarFile(path string) (*carFileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &carFileReader{file: file}
+	if err := r.readHeader(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+func (r *carFileReader) readHeader() error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	roots, version, n, err := readCarV1Header(io.NewSectionReader(r.file, 0, info.Size()))
+	if err != nil {
+		return err
+	}
+
+	switch version {
+	case 1:
+		r.version, r.roots, r.blockOffset, r.dataEnd = 1, roots, n, info.Size()
+	case 2:
+		header := make([]byte, carV2HeaderSize)
+		if _, err := r.file.ReadAt(header, int64(len(carV2Pragma))); err != nil {
+			return fmt.Errorf("%w: reading CARv2 header: %w", ErrInvalidCar, err)
+		}
+
+		dataOffset := int64(binary.LittleEndian.Uint64(header[16:]))
+		dataSize := int64(binary.LittleEndian.Uint64(header[24:]))
+		if dataOffset < n+carV2HeaderSize || dataSize < 0 || dataOffset+dataSize > info.Size() {
+			return fmt.Errorf("%w: CARv2 data payload out of range", ErrInvalidCar)
+		}
+
+		roots, version, n, err = readCarV1Header(io.NewSectionReader(r.file, dataOffset, dataSize))
+		if err != nil {
+			return err
+		}
+
+		if version != 1 {
+			return fmt.Errorf("%w: CARv2 data payload has version %d", ErrInvalidCar, version)
+		}
+		r.version, r.roots, r.blockOffset, r.dataEnd = 2, roots, dataOffset+n, dataOffset+dataSize
+		r.dataOffset = dataOffset
+		r.indexOffset = int64(binary.LittleEndian.Uint64(header[32:]))
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedCarVersion, version)
+	}
+	return nil
+}
+
+// blocks returns an iterator over the block sections of the data payload in the order they appear in the file.
+func (r *carFileReader) blocks() iter.Seq2[carBlock, error] {
+	return func(yield func(carBlock, error) bool) {
+		reader := bufio.NewReaderSize(io.NewSectionReader(r.file, r.blockOffset, r.dataEnd-r.blockOffset), tokenBufferSize)
+		offset := r.blockOffset
+
+		var buf []byte
+		for {
+			size, err := binary.ReadUvarint(reader)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					yield(carBlock{}, fmt.Errorf("%w: reading section length at offset %d: %w", ErrInvalidCar, offset, err))
+				}
+				return
+			}
+
+			if size == 0 || size > carMaxSectionSize {
+				yield(carBlock{}, fmt.Errorf("%w: invalid section length %d at offset %d", ErrInvalidCar, size, offset))
+				return
+			}
+			offset += int64(uvarintSize(size))
+
+			if uint64(cap(buf)) < size {
+				buf = make([]byte, size)
+			}
+			buf = buf[:size]
+
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				yield(carBlock{}, fmt.Errorf("%w: reading section at offset %d: %w", ErrInvalidCar, offset, err))
+				return
+			}
+
+			n, c, err := cid.CidFromBytes(buf)
+			if err != nil {
+				yield(carBlock{}, fmt.Errorf("%w: reading CID at offset %d: %w", ErrInvalidCar, offset, err))
+				return
+			}
+
+			if !yield(carBlock{cid: c, data: buf[n:], offset: offset + int64(n)}, nil) {
+				return
+			}
+			offset += int64(size)
+		}
+	}
+}
+
+// sections returns the offset of the block section for each block in the file, keyed by the multihash of the block's
+// CID. The CARv2 index is used if present; otherwise the section headers are read without reading the block data.
+func (r *carFileReader) sections() (map[string]int64, error) {
+	if r.indexOffset > 0 {
+		return r.readIndex()
+	}
+
+	sections := make(map[string]int64)
+	buf := make([]byte, 2*binary.MaxVarintLen64+128)
+	for offset := r.blockOffset; offset < r.dataEnd; {
+		n, err := r.file.ReadAt(buf[:min(int64(len(buf)), r.dataEnd-offset)], offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		size, vn := binary.Uvarint(buf[:n])
+		if vn <= 0 || size == 0 || size > uint64(r.dataEnd-offset-int64(vn)) {
+			return nil, fmt.Errorf("%w: invalid section length at offset %d", ErrInvalidCar, offset)
+		}
+
+		_, c, err := cid.CidFromBytes(buf[vn:n])
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading CID at offset %d: %w", ErrInvalidCar, offset, err)
+		}
+		sections[string(c.Hash())] = offset
+		offset += int64(vn) + int64(size)
+	}
+	return sections, nil
+}
+
+// readIndex reads a car-multihash-index-sorted CARv2 index.
+func (r *carFileReader) readIndex() (map[string]int64, error) {
+	info, err := r.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(io.NewSectionReader(r.file, r.indexOffset, info.Size()-r.indexOffset), tokenBufferSize)
+	codec, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading index codec: %w", ErrInvalidCar, err)
+	}
+
+	if codec != indexMultihashSorted {
+		return nil, fmt.Errorf("%w: unsupported index codec 0x%x", ErrInvalidCar, codec)
+	}
+
+	var codes uint32
+	if err := binary.Read(br, binary.LittleEndian, &codes); err != nil {
+		return nil, fmt.Errorf("%w: reading index: %w", ErrInvalidCar, err)
+	}
+
+	sections := make(map[string]int64)
+	for i := uint32(0); i < codes; i++ {
+		var header struct {
+			Code   uint64
+			Widths uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+			return nil, fmt.Errorf("%w: reading index: %w", ErrInvalidCar, err)
+		}
+
+		for j := uint32(0); j < header.Widths; j++ {
+			var bucket struct {
+				Width uint32
+				Size  uint64
+			}
+			if err := binary.Read(br, binary.LittleEndian, &bucket); err != nil {
+				return nil, fmt.Errorf("%w: reading index: %w", ErrInvalidCar, err)
+			}
+
+			if bucket.Width <= 8 || bucket.Size%uint64(bucket.Width) != 0 {
+				return nil, fmt.Errorf("%w: invalid index bucket width %d", ErrInvalidCar, bucket.Width)
+			}
+
+			record := make([]byte, bucket.Width)
+			for k := uint64(0); k < bucket.Size/uint64(bucket.Width); k++ {
+				if _, err := io.ReadFull(br, record); err != nil {
+					return nil, fmt.Errorf("%w: reading index: %w", ErrInvalidCar, err)
+				}
+
+				digest := record[:bucket.Width-8]
+				mh, err := multihash.Encode(digest, header.Code)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrInvalidCar, err)
+				}
+				sections[string(mh)] = r.dataOffset + int64(binary.LittleEndian.Uint64(record[bucket.Width-8:]))
+			}
+		}
+	}
+	return sections, nil
+}
+
+// section reads the block section at offset.
+func (r *carFileReader) section(offset int64) (cid.Cid, []byte, error) {
+	head := make([]byte, binary.MaxVarintLen64)
+	n, err := r.file.ReadAt(head, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return cid.Undef, nil, err
+	}
+
+	size, vn := binary.Uvarint(head[:n])
+	if vn <= 0 || size == 0 || size > carMaxSectionSize || offset+int64(vn)+int64(size) > r.dataEnd {
+		return cid.Undef, nil, fmt.Errorf("%w: invalid section length at offset %d", ErrInvalidCar, offset)
+	}
+
+	buf := make([]byte, size)
+	if _, err := r.file.ReadAt(buf, offset+int64(vn)); err != nil {
+		return cid.Undef, nil, fmt.Errorf("%w: reading section at offset %d: %w", ErrInvalidCar, offset, err)
+	}
+
+	cn, c, err := cid.CidFromBytes(buf)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("%w: reading CID at offset %d: %w", ErrInvalidCar, offset, err)
+	}
+	return c, buf[cn:], nil
+}
+
+func (r *carFileReader) close() error {
+	return r.file.Close()
+}
+
+// readCarV1Header reads a varint length-prefixed DAG-CBOR CAR header, returning the roots, the version and the number of
+// bytes read. The CARv2 pragma is read as a header declaring version 2 without roots.
+func readCarV1Header(r io.Reader) ([]cid.Cid, uint64, int64, error) {
+	br := bufio.NewReader(r)
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("%w: reading header length: %w", ErrInvalidCar, err)
+	}
+
+	if size == 0 || size > carMaxHeaderSize {
+		return nil, 0, 0, fmt.Errorf("%w: invalid header length %d", ErrInvalidCar, size)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, 0, 0, fmt.Errorf("%w: reading header: %w", ErrInvalidCar, err)
+	}
+
+	roots, version, err := decodeCarHeader(b)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("%w: %w", ErrInvalidCar, err)
+	}
+	return roots, version, int64(uvarintSize(size)) + int64(size), nil
+}
+
+// decodeCarHeader decodes the DAG-CBOR map holding the roots and version of a CAR header. Keys other than roots and
+// version are skipped.
+func decodeCarHeader(b []byte) ([]cid.Cid, uint64, error) {
+	d := &cborDecoder{b: b}
+	major, n, err := d.head()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if major != cborMap {
+		return nil, 0, errors.New("header is not a map")
+	}
+
+	var (
+		roots   []cid.Cid
+		version uint64
+	)
+	for i := uint64(0); i < n; i++ {
+		key, err := d.text()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch key {
+		case "roots":
+			if roots, err = d.cids(); err != nil {
+				return nil, 0, err
+			}
+		case "version":
+			major, v, err := d.head()
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if major != cborUint {
+				return nil, 0, errors.New("header version is not an integer")
+			}
+			version = v
+		default:
+			if err := d.skip(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return roots, version, nil
+}
+
+// carV1Header returns the varint length-prefixed DAG-CBOR header for a CARv1 payload with a single root.
+func carV1Header(root cid.Cid) []byte {
+	var h []byte
+	h = appendCBORHead(h, cborMap, 2)
+	h = appendCBORText(h, "roots")
+	h = appendCBORHead(h, cborArray, 1)
+	h = appendCBORHead(h, cborTag, cborTagCID)
+	h = appendCBORBytes(h, root.Bytes())
+	h = appendCBORText(h, "version")
+	h = appendCBORHead(h, cborUint, 1)
+	return append(binary.AppendUvarint(nil, uint64(len(h))), h...)
+}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	// cborTagCID is the tag used by DAG-CBOR for CIDs.
+	cborTagCID = 42
+)
+
+// cborDecoder decodes the subset of DAG-CBOR used by CAR headers.
+type cborDecoder struct {
+	b   []byte
+	pos int
+}
+
+// head reads the head of the next data item, returning its major type and argument.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.b) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	major, info := d.b[d.pos]>>5, d.b[d.pos]&0x1f
+	d.pos++
+
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported CBOR additional information %d", info)
+	}
+
+	if d.pos+size > len(d.b) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	var n uint64
+	for _, c := range d.b[d.pos : d.pos+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.pos += size
+	return major, n, nil
+}
+
+// bytes reads n bytes of string or byte string content.
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := d.b[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) text() (string, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return "", err
+	}
+
+	if major != cborText {
+		return "", errors.New("expected a text string")
+	}
+
+	b, err := d.bytes(n)
+	return string(b), err
+}
+
+// cids reads an array of tagged CIDs.
+func (d *cborDecoder) cids() ([]cid.Cid, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	if major != cborArray {
+		return nil, errors.New("header roots is not an array")
+	}
+
+	var cids []cid.Cid
+	for i := uint64(0); i < n; i++ {
+		major, tag, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+
+		if major != cborTag || tag != cborTagCID {
+			return nil, errors.New("header root is not a CID")
+		}
+
+		major, size, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+
+		if major != cborBytes {
+			return nil, errors.New("header root is not a CID")
+		}
+
+		b, err := d.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(b) == 0 || b[0] != 0x00 {
+			return nil, errors.New("header root is missing the identity multibase prefix")
+		}
+
+		c, err := cid.Cast(b[1:])
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+// skip skips the next data item, including any nested items.
+func (d *cborDecoder) skip() error {
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case cborBytes, cborText:
+		_, err = d.bytes(n)
+		return err
+	case cborArray, cborMap:
+		if major == cborMap {
+			n *= 2
+		}
+
+		for i := uint64(0); i < n; i++ {
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		return d.skip()
+	}
+	return nil
+}
+
+func appendCBORText(b []byte, s string) []byte {
+	b = appendCBORHead(b, cborText, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendCBORBytes appends a CID as a DAG-CBOR byte string, which is prefixed with the identity multibase byte.
+func appendCBORBytes(b []byte, c []byte) []byte {
+	b = appendCBORHead(b, cborBytes, uint64(len(c)+1))
+	b = append(b, 0x00)
+	return append(b, c...)
+}
+
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+// writeMultihashIndex writes the records as a car-multihash-index-sorted index: records are grouped by multihash code
+// and then by digest width, and each group is sorted by digest.
+func writeMultihashIndex(w io.Writer, records []carIndexRecord) error {
+	codes := make(map[uint64]map[int][]carIndexRecord)
+	for _, r := range records {
+		if codes[r.code] == nil {
+			codes[r.code] = make(map[int][]carIndexRecord)
+		}
+		codes[r.code][len(r.digest)] = append(codes[r.code][len(r.digest)], r)
+	}
+
+	var b []byte
+	b = binary.AppendUvarint(b, indexMultihashSorted)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(codes)))
+	for _, code := range sortedKeys(codes) {
+		widths := codes[code]
+		b = binary.LittleEndian.AppendUint64(b, code)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(widths)))
+		for _, width := range sortedKeys(widths) {
+			bucket := widths[width]
+			sort.Slice(bucket, func(i int, j int) bool { return bytes.Compare(bucket[i].digest, bucket[j].digest) < 0 })
+
+			b = binary.LittleEndian.AppendUint32(b, uint32(width+8))
+			b = binary.LittleEndian.AppendUint64(b, uint64(len(bucket)*(width+8)))
+			for _, r := range bucket {
+				b = append(b, r.digest...)
+				b = binary.LittleEndian.AppendUint64(b, r.offset)
+			}
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func sortedKeys[K uint64 | int, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i int, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func uvarintSize(v uint64) int {
+	return len(binary.AppendUvarint(nil, v))
+}
@@ -0,0 +1,62 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestCarIndex(t *testing.T) {
+	root, m := writeTestTree(t,
+		testFile{path: "a.txt", size: 100},
+		testFile{path: "dir/b.bin", size: 50000})
+
+	p := filepath.Join(t.TempDir(), "out.car")
+	w := &CarWriter{Version: 2, ChunkSize: 1024, MaxLinks: 4}
+	c, err := w.Write(context.Background(), m, root, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openCarFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	if r.version != 2 || len(r.roots) != 1 || !r.roots[0].Equals(c) || r.indexOffset == 0 {
+		t.Fatalf("expected a CARv2 file with an index rooted at %s, got version %d and roots %v", c, r.version, r.roots)
+	}
+
+	index, err := r.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks int
+	for block, err := range r.blocks() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks++
+
+		offset, ok := index[string(block.cid.Hash())]
+		if !ok {
+			t.Fatalf("block %s is not indexed", block.cid)
+		}
+
+		indexed, data, err := r.section(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !indexed.Equals(block.cid) || !bytes.Equal(data, block.data) {
+			t.Errorf("index offset %d of block %s refers to block %s", offset, block.cid, indexed)
+		}
+	}
+
+	if blocks == 0 || len(index) != blocks {
+		t.Errorf("index has %d entries for %d blocks", len(index), blocks)
+	}
+}
@@ -0,0 +1,228 @@
+package car
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+)
+
+var ErrDuplicatePage = errors.New("duplicate page index")
+
+// CatalogEntry is an entry listed by a page in a Catalog, along with the page index and the CIDs of the CAR that holds
+// it. A file that graphsplit or CarWriter.WriteParts split across CARs has one CatalogEntry for each CAR. For a file
+// recorded as a Reference, the page index and CIDs are those of the entry that holds its content, and Target is that
+// entry's path. CID is the CID of the file in the CAR, if the graphsplit row records it.
+type CatalogEntry struct {
+	File       *cadre.File `json:"file"`
+	Index      int         `json:"page"`
+	CID        string      `json:"cid,omitempty"`
+	FileName   string      `json:"file_name,omitempty"`
+	PayloadCID string      `json:"payload_cid,omitempty"`
+	PieceCID   string      `json:"piece_cid,omitempty"`
+	Target     string      `json:"target,omitempty"`
+}
+
+// CatalogMetadata aggregates the metadata of every page in a Catalog.
+type CatalogMetadata struct {
+	Namespace string      `json:"namespace"`
+	Entries   int         `json:"entries"`
+	Size      int64       `json:"size"`
+	Pages     []*Metadata `json:"pages"`
+}
+
+// Catalog provides access to every Manifest page written under a root directory for a namespace.
+//
+// Pages are discovered by their directory names, which are parsed as page indexes so that namespaces with more than 99
+// pages are ordered correctly. The entries of every page are indexed by path and by sha256 the first time a lookup is
+// made.
+type Catalog struct {
+	Root string
+
+	byHash  map[string][]CatalogEntry
+	byPath  map[string][]CatalogEntry
+	indexed bool
+	mutex   sync.Mutex
+	pages   []*Manifest
+}
+
+// OpenCatalog discovers the pages under root. Subdirectories of root that are not named after a page index or do not
+// contain metadata.json are ignored. If trusted public keys are provided, every page must be signed by one of them.
+func OpenCatalog(root string, trusted ...ed25519.PublicKey) (*Catalog, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("car_catalog: %w", err)
+	}
+
+	c, err := OpenCatalogFS(DirFS(root), trusted...)
+	if err != nil {
+		return nil, err
+	}
+	c.Root = root
+	return c, nil
+}
+
+// OpenCatalogFS discovers the pages in the root directory of fsys in the same way as OpenCatalog. The Root of the
+// returned Catalog is empty.
+func OpenCatalogFS(fsys fs.FS, trusted ...ed25519.PublicKey) (*Catalog, error) {
+	dirs, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("car_catalog: %w", err)
+	}
+
+	c := &Catalog{}
+	seen := make(map[int]string)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		if _, ok := parsePageID(d.Name()); !ok {
+			continue
+		}
+
+		if _, err := fs.Stat(fsys, path.Join(d.Name(), MetadataFileName)); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		src := displayPath(fsys, d.Name())
+		m, err := ReadFS(fsys, d.Name(), trusted...)
+		if err != nil {
+			return nil, fmt.Errorf("car_catalog: %s: %w", src, err)
+		}
+
+		if prev, ok := seen[m.Index()]; ok {
+			return nil, fmt.Errorf("car_catalog: %w: %d: %s and %s", ErrDuplicatePage, m.Index(), prev, src)
+		}
+		seen[m.Index()] = src
+		c.pages = append(c.pages, m)
+	}
+
+	sort.Slice(c.pages, func(i int, j int) bool { return c.pages[i].Index() < c.pages[j].Index() })
+	return c, nil
+}
+
+// Pages returns the pages in the catalog ordered by index.
+func (c *Catalog) Pages() []*Manifest {
+	return c.pages
+}
+
+// Page returns the page with the provided index, or nil if the catalog has no such page.
+func (c *Catalog) Page(index int) *Manifest {
+	i := sort.Search(len(c.pages), func(i int) bool { return c.pages[i].Index() >= index })
+	if i < len(c.pages) && c.pages[i].Index() == index {
+		return c.pages[i]
+	}
+	return nil
+}
+
+// Metadata returns the metadata of every page in the catalog, along with the total number of entries and their size.
+func (c *Catalog) Metadata() CatalogMetadata {
+	var cm CatalogMetadata
+	for _, m := range c.pages {
+		md := m.Metadata()
+		if cm.Namespace == "" {
+			cm.Namespace = md.Namespace
+		}
+		cm.Entries += md.Entries
+		cm.Size += md.Size
+		cm.Pages = append(cm.Pages, &md)
+	}
+	return cm
+}
+
+// Lookup returns the catalog entries for the file at the provided path.
+func (c *Catalog) Lookup(p string) ([]CatalogEntry, error) {
+	if err := c.index(); err != nil {
+		return nil, err
+	}
+	return c.byPath[entryName(p)], nil
+}
+
+// LookupHash returns the catalog entries for files with the provided sha256 digest.
+func (c *Catalog) LookupHash(sha256 string) ([]CatalogEntry, error) {
+	if err := c.index(); err != nil {
+		return nil, err
+	}
+	return c.byHash[strings.ToLower(sha256)], nil
+}
+
+// String returns a string representation of the Catalog.
+func (c *Catalog) String() string {
+	return string(anchor.ToJSONFormatted(c.Metadata()))
+}
+
+func (c *Catalog) index() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.indexed {
+		return nil
+	}
+
+	byHash := make(map[string][]CatalogEntry)
+	byPath := make(map[string][]CatalogEntry)
+	for _, m := range c.pages {
+		files, err := m.GraphsplitFiles()
+		if err != nil {
+			return fmt.Errorf("car_catalog: %w", err)
+		}
+
+		for _, f := range files {
+			ce := CatalogEntry{
+				File:       f.File,
+				Index:      m.Index(),
+				CID:        f.CID,
+				FileName:   f.FileName,
+				PayloadCID: f.PayloadCID,
+				PieceCID:   f.PieceCID,
+			}
+
+			if ce.PayloadCID == "" {
+				ce.PayloadCID = m.PayloadCID()
+			}
+
+			name := entryName(f.File.Path)
+			byPath[name] = append(byPath[name], ce)
+
+			if digest := digestOf(f.File); digest != "" {
+				byHash[digest] = append(byHash[digest], ce)
+			}
+		}
+	}
+
+	// References resolve to the catalog entries of the files that hold their content.
+	for _, m := range c.pages {
+		for r, err := range m.References() {
+			if err != nil {
+				return fmt.Errorf("car_catalog: %w", err)
+			}
+
+			for _, target := range byPath[entryName(r.Path)] {
+				if target.Index != r.Index {
+					continue
+				}
+
+				ce := target
+				ce.File = r.Entry
+				ce.Target = target.File.Path
+				name := entryName(r.Entry.Path)
+				byPath[name] = append(byPath[name], ce)
+
+				if digest := digestOf(r.Entry); digest != "" {
+					byHash[digest] = append(byHash[digest], ce)
+				}
+			}
+		}
+	}
+
+	c.byHash, c.byPath, c.indexed = byHash, byPath, true
+	return nil
+}
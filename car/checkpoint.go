@@ -0,0 +1,132 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/transientvariable/cadre"
+
+	json "github.com/json-iterator/go"
+)
+
+// DefaultCheckpointInterval is the number of files added between checkpoints when Builder.CheckpointInterval is zero.
+const DefaultCheckpointInterval = 1000
+
+var ErrCheckpointMismatch = errors.New("checkpoint does not match the builder")
+
+// BuildCheckpoint records the progress of a Builder walking a directory tree, so that an interrupted build can be
+// resumed without hashing the files it had already added.
+//
+// LastPage is the index of the last page written to the output directory, or -1 if no page was completed. LastPath is
+// the path of the last file added, in the order in which fs.WalkDir visits files. Entries and References hold the
+// partial page, i.e. the files added after the last completed page.
+type BuildCheckpoint struct {
+	Namespace  string        `json:"namespace"`
+	Index      uint          `json:"index"`
+	LastPage   int           `json:"last_page"`
+	LastPath   string        `json:"last_path"`
+	Files      int           `json:"files"`
+	Entries    []*cadre.File `json:"entries"`
+	References []Reference   `json:"references"`
+}
+
+// ReadCheckpoint reads the checkpoint file at path.
+func ReadCheckpoint(path string) (*BuildCheckpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp BuildCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("car_builder: %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// checkpoint writes the progress of the Builder to its checkpoint file.
+func (b *Builder) checkpoint() error {
+	cp := BuildCheckpoint{
+		Namespace: b.Namespace,
+		Index:     b.Index,
+		LastPage:  int(b.Index) + len(b.pages) - 1,
+		LastPath:  b.lastPath,
+		Files:     b.files,
+	}
+
+	if len(b.pages) == 0 {
+		cp.LastPage = -1
+	}
+
+	if b.page != nil {
+		b.page.mutex.RLock()
+		defer b.page.mutex.RUnlock()
+		cp.Entries, cp.References = b.page.entries, b.page.references
+	}
+
+	return writeFileAtomic(b.Checkpoint, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cp)
+	})
+}
+
+// resume restores the progress recorded in the checkpoint file, if it exists. Completed pages are read back from
+// Output, and the partial page is restored in the order in which its files were added.
+func (b *Builder) resume() error {
+	cp, err := ReadCheckpoint(b.Checkpoint)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if cp.Namespace != b.Namespace || cp.Index != b.Index {
+		return fmt.Errorf("%w: %s: namespace %s, index %d", ErrCheckpointMismatch, b.Checkpoint, cp.Namespace, cp.Index)
+	}
+
+	b.pages, b.page = nil, nil
+	for index := int(cp.Index); index <= cp.LastPage; index++ {
+		m, err := ReadWithIndex(b.Output, index)
+		if err != nil {
+			return err
+		}
+		b.pages = append(b.pages, m)
+	}
+
+	if len(cp.Entries) > 0 || len(cp.References) > 0 {
+		if err := b.startPage(); err != nil {
+			return err
+		}
+		b.page.Add(cp.Entries...)
+		b.page.AddReference(cp.References...)
+	}
+
+	if b.Dedup != nil {
+		pages := b.pages
+		if b.page != nil {
+			pages = append(pages[:len(pages):len(pages)], b.page)
+		}
+
+		if err := b.Dedup.Seed(pages...); err != nil {
+			return err
+		}
+	}
+	b.lastPath, b.files = cp.LastPath, cp.Files
+	return nil
+}
+
+// walkBefore reports whether fs.WalkDir visits the slash-separated path a before b. WalkDir visits the entries of a
+// directory in lexical order and each directory before its contents, which differs from the lexical order of the
+// full paths, e.g. "a/b" is visited before "a.txt".
+func walkBefore(a string, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
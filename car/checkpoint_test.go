@@ -0,0 +1,104 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var errFault = errors.New("fault")
+
+// faultFS fails to open the named file.
+type faultFS struct {
+	fs.FS
+	name string
+}
+
+func (f faultFS) Open(name string) (fs.File, error) {
+	if name == f.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errFault}
+	}
+	return f.FS.Open(name)
+}
+
+func TestBuildResume(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fstest.MapFS{}
+	var names []string
+	for _, name := range []string{"a.txt", "a/b.txt", "a/c/d.txt", "a/c/e.txt", "b.txt", "b/copy.txt", "c.txt", "d/e/f.txt", "z.txt"} {
+		data := []byte(name)
+		if filepath.Base(name) == "copy.txt" {
+			data = []byte("a.txt")
+		}
+		src[name] = &fstest.MapFile{Data: data, Mode: 0644, ModTime: mtime}
+		names = append(names, name)
+	}
+
+	build := func(output string, checkpoint string, fsys fs.FS) ([]*Manifest, error) {
+		b := NewBuilder("ns", output)
+		b.Checkpoint = checkpoint
+		b.CheckpointInterval = 2
+		b.Dedup = NewDeduplicator()
+		b.MaxEntries = 3
+		return b.BuildFS(context.Background(), fsys)
+	}
+
+	clean := t.TempDir()
+	expected, err := build(clean, "", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			output := t.TempDir()
+			checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+			if _, err := build(output, checkpoint, faultFS{FS: src, name: name}); !errors.Is(err, errFault) {
+				t.Fatalf("expected the build to fail, got %v", err)
+			}
+
+			pages, err := build(output, checkpoint, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(pages) != len(expected) {
+				t.Fatalf("resumed build has %d pages, expected %d", len(pages), len(expected))
+			}
+
+			for _, m := range expected {
+				for _, file := range []string{EntriesFileName, ReferencesFileName} {
+					compareFiles(t, filepath.Join(clean, m.Id(), file), filepath.Join(output, m.Id(), file))
+				}
+			}
+
+			if _, err := os.Stat(checkpoint); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected the checkpoint to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func compareFiles(t *testing.T, expected string, actual string) {
+	t.Helper()
+
+	e, err := os.ReadFile(expected)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	a, err := os.ReadFile(actual)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a, e) {
+		t.Errorf("%s differs from %s:\n%s\nexpected:\n%s", actual, expected, a, e)
+	}
+}
@@ -0,0 +1,155 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+
+	json "github.com/json-iterator/go"
+)
+
+const (
+	// ManifestVersion is the version of the manifest format written by Manifest.WriteTo.
+	//
+	// Version 1 pages have no version in metadata.json and list the columns in EntriesCSVFields. Version 2 pages record
+	// the version and any columns from EntriesCSVExtraFields that follow them in entries.csv.
+	ManifestVersion = 2
+
+	// EntriesCSVExtraFields lists the optional columns that can be written to entries.csv after EntriesCSVFields.
+	EntriesCSVExtraFields = "cid,mode,uid,gid,owner,group,mime_type,type,inode,md5,sha1,sha512,adler32,ssdeep,ctime,created,accessed,url,attributes"
+)
+
+var (
+	ErrUnknownColumn              = errors.New("unknown entries.csv column")
+	ErrUnsupportedManifestVersion = errors.New("unsupported manifest version")
+)
+
+// entryColumn formats and parses a column of entries.csv.
+type entryColumn struct {
+	format func(e *cadre.File) string
+	parse  func(e *cadre.File, v string) error
+}
+
+var entryColumns = map[string]entryColumn{
+	"name": stringColumn(func(e *cadre.File) *string { return &e.Name }),
+	"path": stringColumn(func(e *cadre.File) *string { return &e.Path }),
+	"size": {
+		format: func(e *cadre.File) string { return strconv.FormatInt(e.Size, 10) },
+		parse: func(e *cadre.File, v string) (err error) {
+			e.Size, err = strconv.ParseInt(v, 10, 64)
+			return err
+		},
+	},
+	"sha256":    hashColumn(func(h *ecs.Hash) *string { return &h.Sha256 }),
+	"mtime":     timeColumn(func(e *cadre.File) **time.Time { return &e.Mtime }),
+	"cid":       stringColumn(func(e *cadre.File) *string { return &e.CID }),
+	"mode":      stringColumn(func(e *cadre.File) *string { return &e.Mode }),
+	"uid":       stringColumn(func(e *cadre.File) *string { return &e.UID }),
+	"gid":       stringColumn(func(e *cadre.File) *string { return &e.GID }),
+	"owner":     stringColumn(func(e *cadre.File) *string { return &e.Owner }),
+	"group":     stringColumn(func(e *cadre.File) *string { return &e.Group }),
+	"mime_type": stringColumn(func(e *cadre.File) *string { return &e.MimeType }),
+	"type":      stringColumn(func(e *cadre.File) *string { return &e.Type }),
+	"inode":     stringColumn(func(e *cadre.File) *string { return &e.Inode }),
+	"md5":       hashColumn(func(h *ecs.Hash) *string { return &h.Md5 }),
+	"sha1":      hashColumn(func(h *ecs.Hash) *string { return &h.Sha1 }),
+	"sha512":    hashColumn(func(h *ecs.Hash) *string { return &h.Sha512 }),
+	"adler32":   hashColumn(func(h *ecs.Hash) *string { return &h.Adler32 }),
+	"ssdeep":    hashColumn(func(h *ecs.Hash) *string { return &h.Ssdeep }),
+	"ctime":     timeColumn(func(e *cadre.File) **time.Time { return &e.Ctime }),
+	"created":   timeColumn(func(e *cadre.File) **time.Time { return &e.Created }),
+	"accessed":  timeColumn(func(e *cadre.File) **time.Time { return &e.Accessed }),
+	"url":       stringColumn(func(e *cadre.File) *string { return &e.URL }),
+	"attributes": {
+		format: func(e *cadre.File) string {
+			if len(e.Attributes) == 0 {
+				return ""
+			}
+			b, _ := json.Marshal(e.Attributes)
+			return string(b)
+		},
+		parse: func(e *cadre.File, v string) error {
+			return json.Unmarshal([]byte(v), &e.Attributes)
+		},
+	},
+}
+
+func stringColumn(field func(e *cadre.File) *string) entryColumn {
+	return entryColumn{
+		format: func(e *cadre.File) string { return *field(e) },
+		parse: func(e *cadre.File, v string) error {
+			*field(e) = v
+			return nil
+		},
+	}
+}
+
+// hashColumn is a column holding one of the digests in cadre.File.Hash, which is allocated when a digest is parsed.
+func hashColumn(field func(h *ecs.Hash) *string) entryColumn {
+	return entryColumn{
+		format: func(e *cadre.File) string {
+			if e.Hash == nil {
+				return ""
+			}
+			return *field(e.Hash)
+		},
+		parse: func(e *cadre.File, v string) error {
+			if e.Hash == nil {
+				e.Hash = &ecs.Hash{}
+			}
+			*field(e.Hash) = v
+			return nil
+		},
+	}
+}
+
+// timeColumn is a column holding an RFC 3339 timestamp. A nil or zero time is written as an empty field.
+func timeColumn(field func(e *cadre.File) **time.Time) entryColumn {
+	return entryColumn{
+		format: func(e *cadre.File) string {
+			if t := *field(e); t != nil && !t.IsZero() {
+				return t.Format(time.RFC3339Nano)
+			}
+			return ""
+		},
+		parse: func(e *cadre.File, v string) error {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return err
+			}
+			*field(e) = &t
+			return nil
+		},
+	}
+}
+
+// entryColumnNames returns the columns written to entries.csv for the provided extra columns.
+func entryColumnNames(extra []string) []string {
+	return append(strings.Split(EntriesCSVFields, ","), extra...)
+}
+
+// normalizeColumns validates the provided extra column names, dropping duplicates and columns that are always written.
+func normalizeColumns(columns []string) ([]string, error) {
+	extra := strings.Split(EntriesCSVExtraFields, ",")
+	base := strings.Split(EntriesCSVFields, ",")
+
+	var normalized []string
+	for _, c := range columns {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if slices.Contains(base, c) || slices.Contains(normalized, c) {
+			continue
+		}
+
+		if !slices.Contains(extra, c) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, c)
+		}
+		normalized = append(normalized, c)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,248 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/minio/sha256-simd"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// fr32 padding expands every 127 bytes of payload, i.e. four 254-bit field elements, to 128 bytes.
+	fr32UnpaddedChunk = 127
+	fr32PaddedChunk   = 128
+	fr32BufferChunks  = 1024
+
+	commpNodeSize = 32
+	commpMaxDepth = 64
+)
+
+var ErrPieceCommitmentMismatch = errors.New("piece commitment does not match")
+
+// zeroCommitments holds the root of a tree of 2^i zero leaves at index i.
+var zeroCommitments = func() [commpMaxDepth][commpNodeSize]byte {
+	var zc [commpMaxDepth][commpNodeSize]byte
+	for i := 1; i < commpMaxDepth; i++ {
+		zc[i] = hashNodes(&zc[i-1], &zc[i-1])
+	}
+	return zc
+}()
+
+// PieceCommitment is the Filecoin piece commitment (CommP) of a payload such as a CAR file.
+//
+// PayloadSize is the size of the payload in bytes and PieceSize is the padded piece size in bytes, matching the units
+// recorded by GraphsplitManifestEntry.PayloadSize and GraphsplitManifestEntry.PieceSize.
+type PieceCommitment struct {
+	PieceCID    cid.Cid
+	PayloadSize int64
+	PieceSize   int64
+}
+
+// ComputePieceCommitment reads r to EOF and computes the piece commitment of the data read.
+//
+// The payload is Fr32 padded, zero-filled to the smallest power-of-two piece that holds it, and reduced to a single root
+// using a binary Merkle tree of sha256 digests truncated to 254 bits.
+func ComputePieceCommitment(r io.Reader) (PieceCommitment, error) {
+	var (
+		tree    commpTree
+		payload int64
+		in      = make([]byte, fr32UnpaddedChunk*fr32BufferChunks)
+		out     = make([]byte, fr32PaddedChunk*fr32BufferChunks)
+	)
+
+	for {
+		n, err := io.ReadFull(r, in)
+		if n > 0 {
+			payload += int64(n)
+			chunks := (n + fr32UnpaddedChunk - 1) / fr32UnpaddedChunk
+			clear(in[n : chunks*fr32UnpaddedChunk])
+			fr32Pad(in[:chunks*fr32UnpaddedChunk], out[:chunks*fr32PaddedChunk])
+
+			for i := 0; i < chunks*fr32PaddedChunk; i += commpNodeSize {
+				tree.push(0, [commpNodeSize]byte(out[i:i+commpNodeSize]))
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return PieceCommitment{}, err
+		}
+	}
+
+	pieceSize := PaddedPieceSize(payload)
+	root := tree.root(pieceSize / commpNodeSize)
+
+	mh, err := multihash.Encode(root[:], multihash.SHA2_256_TRUNC254_PADDED)
+	if err != nil {
+		return PieceCommitment{}, err
+	}
+	return PieceCommitment{
+		PieceCID:    cid.NewCidV1(cid.FilCommitmentUnsealed, mh),
+		PayloadSize: payload,
+		PieceSize:   pieceSize,
+	}, nil
+}
+
+// ComputePieceCommitmentFile computes the piece commitment of the file at path.
+func ComputePieceCommitmentFile(path string) (PieceCommitment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PieceCommitment{}, err
+	}
+	defer func(f *os.File) {
+		if err := f.Close(); err != nil {
+			fmt.Println(fmt.Errorf("car_commp: %w", err))
+		}
+	}(f)
+	return ComputePieceCommitment(f)
+}
+
+// SetPieceCommitment sets the piece CID, hash and size of the entry from the provided piece commitment.
+func (e *GraphsplitManifestEntry) SetPieceCommitment(pc PieceCommitment) {
+	e.PieceCID = pc.PieceCID.String()
+	e.PieceHash = pc.PieceCID.Hash().HexString()
+	e.PieceSize = pc.PieceSize
+}
+
+// VerifyPieceCommitment computes the piece commitment of the CAR file at path and compares it to the piece CID, piece
+// size and payload size recorded for the entry.
+func (e GraphsplitManifestEntry) VerifyPieceCommitment(path string) error {
+	pc, err := ComputePieceCommitmentFile(path)
+	if err != nil {
+		return err
+	}
+
+	if pc.PieceCID.String() != e.PieceCID || pc.PieceSize != e.PieceSize || pc.PayloadSize != e.PayloadSize {
+		return fmt.Errorf("car_commp: %w: %s: computed %s (piece size: %d, payload size: %d), recorded %s (piece size: %d, payload size: %d)",
+			ErrPieceCommitmentMismatch,
+			path,
+			pc.PieceCID,
+			pc.PieceSize,
+			pc.PayloadSize,
+			e.PieceCID,
+			e.PieceSize,
+			e.PayloadSize)
+	}
+	return nil
+}
+
+// CarPath returns the path of the CAR file for the entry in the directory dir. Graphsplit names CAR files after either
+// the payload CID or the graph name, so both are tried in turn.
+func (e GraphsplitManifestEntry) CarPath(dir string) (string, error) {
+	var candidates []string
+	for _, name := range []string{e.CarFileName(), e.FileName + CarFileExtension, e.FileName} {
+		if name == CarFileExtension || name == "" {
+			continue
+		}
+
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, nil
+		}
+		candidates = append(candidates, name)
+	}
+	return "", fmt.Errorf("car_commp: no CAR file found in %s: %v: %w", dir, candidates, os.ErrNotExist)
+}
+
+// VerifyPieceCommitments verifies the piece commitment of every entry in the manifest against its CAR file in dir.
+// All entries are verified and the returned error joins the errors for each entry that could not be verified.
+func (m GraphsplitManifest) VerifyPieceCommitments(dir string) error {
+	var errs []error
+	for _, e := range m.Entries {
+		p, err := e.CarPath(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := e.VerifyPieceCommitment(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// commpTree incrementally computes the root of a binary Merkle tree, holding at most one pending node per level.
+type commpTree struct {
+	pending [commpMaxDepth]*[commpNodeSize]byte
+}
+
+func (t *commpTree) push(level int, node [commpNodeSize]byte) {
+	for ; t.pending[level] != nil; level++ {
+		node = hashNodes(t.pending[level], &node)
+		t.pending[level] = nil
+	}
+	t.pending[level] = &node
+}
+
+// root fills the tree with zero leaves up to the provided number of leaves, which must be a power of two, and returns
+// the root.
+func (t *commpTree) root(leaves int64) [commpNodeSize]byte {
+	depth := 0
+	for l := leaves; l > 1; l >>= 1 {
+		depth++
+	}
+
+	for level := 0; level < depth; level++ {
+		if t.pending[level] != nil {
+			t.push(level, zeroCommitments[level])
+		}
+	}
+
+	if t.pending[depth] == nil {
+		return zeroCommitments[depth]
+	}
+	return *t.pending[depth]
+}
+
+// hashNodes returns the sha256 digest of the concatenated nodes with the two most significant bits cleared, so that
+// the result is a valid 254-bit field element.
+func hashNodes(left *[commpNodeSize]byte, right *[commpNodeSize]byte) [commpNodeSize]byte {
+	h := sha256.New()
+	h.Write(left[:])
+	h.Write(right[:])
+
+	var node [commpNodeSize]byte
+	h.Sum(node[:0])
+	node[commpNodeSize-1] &= 0x3f
+	return node
+}
+
+// fr32Pad expands in, which must be a multiple of 127 bytes, into out by inserting two zero bits after every 254 bits.
+func fr32Pad(in []byte, out []byte) {
+	for chunk := 0; chunk < len(in)/fr32UnpaddedChunk; chunk++ {
+		src := in[chunk*fr32UnpaddedChunk : (chunk+1)*fr32UnpaddedChunk]
+		dst := out[chunk*fr32PaddedChunk : (chunk+1)*fr32PaddedChunk]
+
+		copy(dst[:31], src[:31])
+		t := src[31] >> 6
+		dst[31] = src[31] & 0x3f
+
+		for i := 32; i < 64; i++ {
+			dst[i] = src[i]<<2 | t
+			t = src[i] >> 6
+		}
+		t = src[63] >> 4
+		dst[63] &= 0x3f
+
+		for i := 64; i < 96; i++ {
+			dst[i] = src[i]<<4 | t
+			t = src[i] >> 4
+		}
+		t = src[95] >> 2
+		dst[95] &= 0x3f
+
+		for i := 96; i < 127; i++ {
+			dst[i] = src[i]<<6 | t
+			t = src[i] >> 2
+		}
+		dst[127] = t & 0x3f
+	}
+}
@@ -0,0 +1,90 @@
+package car
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPattern returns size bytes of a repeating, non-zero pattern.
+func testPattern(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i*31 + 7)
+	}
+	return b
+}
+
+func TestComputePieceCommitment(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		pieceCID  string
+		pieceSize int64
+	}{
+		{
+			name:      "zeros",
+			data:      make([]byte, 2032),
+			pieceCID:  "baga6ea4seaqpy7usqklokfx2vxuynmupslkeutzexe2uqurdg5vhtebhxqmpqmy",
+			pieceSize: 2048,
+		},
+		{
+			name:      "partial chunk",
+			data:      testPattern(5000),
+			pieceCID:  "baga6ea4seaqgi5mb253ft44vrbtbiinsxwoggeqf4rzf35shnj2iyo7ujfcvyoa",
+			pieceSize: 8192,
+		},
+		{
+			name:      "multiple buffers",
+			data:      testPattern(300000),
+			pieceCID:  "baga6ea4seaqno3jju7g3kntfd3mq72nvdopluu24udxsl67cfkd2i7yjsavxoea",
+			pieceSize: 524288,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc, err := ComputePieceCommitment(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pc.PieceCID.String() != tt.pieceCID {
+				t.Errorf("piece CID %s, expected %s", pc.PieceCID, tt.pieceCID)
+			}
+
+			if pc.PieceSize != tt.pieceSize || pc.PayloadSize != int64(len(tt.data)) {
+				t.Errorf("piece size %d and payload size %d, expected %d and %d", pc.PieceSize, pc.PayloadSize,
+					tt.pieceSize, len(tt.data))
+			}
+		})
+	}
+}
+
+func TestVerifyPieceCommitment(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "piece.car")
+	if err := os.WriteFile(p, testPattern(5000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := ComputePieceCommitmentFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := GraphsplitManifestEntry{PayloadSize: pc.PayloadSize}
+	e.SetPieceCommitment(pc)
+	if err := e.VerifyPieceCommitment(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(p, append(testPattern(4999), 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.VerifyPieceCommitment(p); !errors.Is(err, ErrPieceCommitmentMismatch) {
+		t.Fatalf("expected ErrPieceCommitmentMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package car
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"strings"
+)
+
+// EntryError records the location of a row in entries.csv or manifest.csv that could not be read or parsed. Line and
+// Column are 1-based and refer to the position of the offending field, or are zero if the position is unknown.
+type EntryError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError records the index of the field that caused a parse error so that it can be resolved to a line and column.
+type fieldError struct {
+	field int
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.err.Error()
+}
+
+// newEntryError creates an EntryError for the provided error using the position information from the reader.
+func newEntryError(name string, reader *csv.Reader, err error) *EntryError {
+	ee := &EntryError{File: name, Err: err}
+
+	var pe *csv.ParseError
+	var fe *fieldError
+	switch {
+	case errors.As(err, &pe):
+		ee.Line, ee.Column, ee.Err = pe.Line, pe.Column, pe.Err
+	case errors.As(err, &fe):
+		ee.Err = fe.err
+		if fe.field >= 0 {
+			ee.Line, ee.Column = reader.FieldPos(fe.field)
+		}
+	}
+	return ee
+}
+
+// csvHeader maps the column names of a CSV header row to their field index.
+type csvHeader map[string]int
+
+// newCSVReader returns a csv.Reader that tolerates bare quotes in unquoted fields, which may be present in files
+// written before fields were quoted.
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	return reader
+}
+
+// readHeader reads the header row from the provided reader. An empty file yields an empty csvHeader.
+func readHeader(r *csv.Reader) (csvHeader, error) {
+	record, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return csvHeader{}, nil
+		}
+		return nil, err
+	}
+
+	header := make(csvHeader, len(record))
+	for i, name := range record {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	return header, nil
+}
+
+// index returns the field index of the first matching column name, or -1 if none of the names are present.
+func (h csvHeader) index(names ...string) int {
+	for _, name := range names {
+		if i, ok := h[name]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// value returns the field for the first matching column name, or the empty string if none of the names are present.
+func (h csvHeader) value(record []string, names ...string) string {
+	if i := h.index(names...); i >= 0 && i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+// readRows returns an iterator over the rows of the named CSV file in fsys, parsed with the provided function. Iteration
+// stops after the first error, which is an *EntryError when a row cannot be read or parsed.
+func readRows[T any](fsys fs.FS, name string, parse func(header csvHeader, record []string) (T, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		path := displayPath(fsys, name)
+		f, err := fsys.Open(name)
+		if err != nil {
+			yield(zero, fmt.Errorf("manifest: %w", err))
+			return
+		}
+		defer func(f fs.File) {
+			if err := f.Close(); err != nil {
+				fmt.Println(fmt.Errorf("manifest: %w", err))
+			}
+		}(f)
+
+		reader := newCSVReader(f)
+		header, err := readHeader(reader)
+		if err != nil {
+			yield(zero, fmt.Errorf("manifest: %w", newEntryError(path, reader, err)))
+			return
+		}
+
+		for {
+			record, err := reader.Read()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					yield(zero, fmt.Errorf("manifest: %w", newEntryError(path, reader, err)))
+				}
+				return
+			}
+
+			row, err := parse(header, record)
+			if err != nil {
+				yield(zero, fmt.Errorf("manifest: %w", newEntryError(path, reader, err)))
+				return
+			}
+
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readAllRows reads every row of the named CSV file in fsys, parsed with the provided function, returning the first
+// error encountered, if any.
+func readAllRows[T any](fsys fs.FS, name string, parse func(header csvHeader, record []string) (T, error)) ([]T, error) {
+	var rows []T
+	for row, err := range readRows(fsys, name, parse) {
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
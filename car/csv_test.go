@@ -0,0 +1,206 @@
+package car
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPayloadCID = "bafybeid6xwkx4t72ppm767jvjgvw6ozxazs4xh7rswwyw4pwqc4eqtxxre"
+	testPieceCID   = "baga6ea4seaqgi5mb253ft44vrbtbiinsxwoggeqf4rzf35shnj2iyo7ujfcvyoa"
+)
+
+func TestEntriesCSVQuoting(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "comma", path: "dir/a,b.txt"},
+		{name: "quote", path: `dir/say "hi".txt`},
+		{name: "newline", path: "dir/line\nbreak.txt"},
+		{name: "leading space", path: " dir/ lead.txt"},
+		{name: "all", path: "\"a\",\nb c,d.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			entry := testEntry(tt.path, "aa", 1)
+			entry.Name = filepath.Base(tt.path)
+			entry.Mtime = &mtime
+
+			src := t.TempDir()
+			m := NewManifest("ns", 0)
+			m.Add(entry, testEntry("other.txt", "bb", 2))
+			if err := m.WriteTo(src); err != nil {
+				t.Fatal(err)
+			}
+
+			read, err := Read(filepath.Join(src, "00"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := read.ReadAllEntries()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 2 {
+				t.Fatalf("read %d entries, expected 2", len(entries))
+			}
+
+			e := entries[1]
+			if e.Name != entry.Name || e.Path != entry.Path || e.Size != 1 || !e.Mtime.Equal(mtime) {
+				t.Errorf("read %q %q %d %v, expected %q %q 1 %v", e.Name, e.Path, e.Size, e.Mtime, entry.Name,
+					entry.Path, mtime)
+			}
+		})
+	}
+}
+
+func TestReadUnquotedEntriesCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		csv   string
+		paths []string
+	}{
+		{
+			name:  "plain",
+			csv:   "name,path,size,sha256,mtime\na.txt,dir/a.txt,1,aa,2024-01-02T03:04:05Z\nb.txt,b.txt,2,bb,2024-01-02T03:04:06Z\n",
+			paths: []string{"dir/a.txt", "b.txt"},
+		},
+		{
+			name:  "bare quotes",
+			csv:   "name,path,size,sha256,mtime\nit's \"q\".txt,dir/it's \"q\".txt,1,aa,2024-01-02T03:04:05Z\n",
+			paths: []string{`dir/it's "q".txt`},
+		},
+		{
+			name:  "empty mtime",
+			csv:   "name,path,size,sha256,mtime\na.txt,a.txt,1,,\n",
+			paths: []string{"a.txt"},
+		},
+		{
+			name:  "no trailing newline",
+			csv:   "name,path,size,sha256,mtime\na.txt,a.txt,1,aa,2024-01-02T03:04:05Z",
+			paths: []string{"a.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, EntriesFileName), []byte(tt.csv), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := readAllRows(DirFS(dir), EntriesFileName, parseEntry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != len(tt.paths) {
+				t.Fatalf("read %d entries, expected %d", len(entries), len(tt.paths))
+			}
+
+			for i, p := range tt.paths {
+				if entries[i].Path != p || entries[i].Size != int64(i+1) {
+					t.Errorf("read %q (%d bytes), expected %q (%d bytes)", entries[i].Path, entries[i].Size, p, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestReadGraphsplitManifestCSV(t *testing.T) {
+	row := testPayloadCID + ",ns-00," + testPieceCID + ",1000,2048"
+	detail := `"{""Name"":"""",""Hash"":""` + testPayloadCID + `"",""Size"":1000,""Link"":[{""Name"":""a,\""b\"".txt"",""Hash"":""` +
+		testPayloadCID + `"",""Size"":900,""Link"":null}]}"`
+
+	tests := []struct {
+		name   string
+		csv    string
+		detail bool
+	}{
+		{
+			name:   "graphsplit",
+			csv:    strings.ReplaceAll(GraphsplitCSVFields+"\n"+row+","+detail+"\n", "\n", "\r\n"),
+			detail: true,
+		},
+		{
+			name:   "quoted",
+			csv:    GraphsplitCSVFields + "\n" + row + "," + detail + "\n",
+			detail: true,
+		},
+		{
+			name: "unquoted",
+			csv:  "playload_cid,filename,piece_cid,payload_size,piece_size\n" + row + "\n",
+		},
+		{
+			name: "reordered",
+			csv:  "piece_cid,payload_cid,filename,piece_size,payload_size\n" + testPieceCID + "," + testPayloadCID + ",ns-00,2048,1000\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readEntries(strings.NewReader(tt.csv), GraphsplitManifestFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 1 {
+				t.Fatalf("read %d rows, expected 1", len(entries))
+			}
+
+			e := entries[0]
+			if e.PayloadCID != testPayloadCID || e.FileName != "ns-00" || e.PieceCID != testPieceCID ||
+				e.PayloadSize != 1000 || e.PieceSize != 2048 {
+				t.Errorf("unexpected row: %s", e)
+			}
+
+			if !tt.detail {
+				if e.Detail != nil {
+					t.Errorf("unexpected detail: %v", e.Detail)
+				}
+				return
+			}
+
+			if e.Detail == nil || len(e.Detail.Link) != 1 || e.Detail.Link[0].Name != `a,"b".txt` {
+				t.Errorf("unexpected detail: %v", e.Detail)
+			}
+		})
+	}
+}
+
+func TestWriteGraphsplitManifestCSV(t *testing.T) {
+	m := GraphsplitManifest{Entries: []GraphsplitManifestEntry{{
+		FileName:    "ns,00",
+		PayloadCID:  testPayloadCID,
+		PayloadSize: 1000,
+		PieceCID:    testPieceCID,
+		PieceSize:   2048,
+		Detail:      &GraphsplitNode{Hash: testPayloadCID, Link: []GraphsplitNode{{Name: "a\n\"b\".txt"}}},
+	}}}
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(b.String(), GraphsplitCSVFields+"\r\n") {
+		t.Errorf("expected a CRLF header row, got %q", b.String())
+	}
+
+	entries, err := readEntries(strings.NewReader(b.String()), GraphsplitManifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].FileName != "ns,00" || entries[0].Detail.Link[0].Name != "a\n\"b\".txt" {
+		t.Errorf("unexpected rows: %v", entries)
+	}
+}
@@ -0,0 +1,184 @@
+package car
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+
+	json "github.com/json-iterator/go"
+)
+
+const (
+	// DefaultDealDuration is the default deal duration in epochs, which is 180 days at 30 seconds per epoch.
+	DefaultDealDuration = 518400
+
+	// DealProposalCSVFields is the header row written by WriteDealProposalsCSV. The column names match the flags of the
+	// boost deal command.
+	DealProposalCSVFields = "provider,commp,piece-size,car-size,payload-cid,file-name,http-url,start-epoch,duration,storage-price,verified,remove-unsealed-copy,skip-ipni-announce"
+)
+
+var ErrInvalidDealConfig = errors.New("invalid deal configuration")
+
+// DealConfig holds the deal terms applied to every proposal exported from a GraphsplitManifest.
+//
+// Duration and StartEpoch are in epochs; a zero StartEpoch leaves the start epoch to the client. StoragePrice is in
+// attoFIL per GiB per epoch. If URLPrefix is set, each proposal's HTTP URL is the CAR file name appended to it, for
+// providers that fetch CARs over HTTP rather than importing them offline.
+type DealConfig struct {
+	Provider           string
+	Duration           int64
+	StartEpoch         int64
+	StoragePrice       int64
+	URLPrefix          string
+	Verified           bool
+	RemoveUnsealedCopy bool
+	SkipIPNIAnnounce   bool
+}
+
+// NewDealConfig creates a new DealConfig for verified deals with the provider using DefaultDealDuration.
+func NewDealConfig(provider string) *DealConfig {
+	return &DealConfig{
+		Provider: provider,
+		Duration: DefaultDealDuration,
+		Verified: true,
+	}
+}
+
+// DealProposal holds the parameters of an offline deal for a single CAR. The JSON field names match the flags of the
+// boost deal command.
+type DealProposal struct {
+	Provider           string `json:"provider"`
+	PieceCID           string `json:"commp"`
+	PieceSize          int64  `json:"piece-size"`
+	CarSize            int64  `json:"car-size"`
+	PayloadCID         string `json:"payload-cid"`
+	FileName           string `json:"file-name"`
+	URL                string `json:"http-url,omitempty"`
+	StartEpoch         int64  `json:"start-epoch,omitempty"`
+	Duration           int64  `json:"duration"`
+	StoragePrice       int64  `json:"storage-price"`
+	Verified           bool   `json:"verified"`
+	RemoveUnsealedCopy bool   `json:"remove-unsealed-copy"`
+	SkipIPNIAnnounce   bool   `json:"skip-ipni-announce"`
+}
+
+// String returns a string representation of the DealProposal.
+func (p DealProposal) String() string {
+	return string(anchor.ToJSONFormatted(p))
+}
+
+// CarFileName returns the name of the CAR file for the entry, which graphsplit names after the payload CID.
+func (e GraphsplitManifestEntry) CarFileName() string {
+	return e.PayloadCID + CarFileExtension
+}
+
+// DealProposals creates a deal proposal for each CAR recorded in the manifest using the terms in cfg. Every entry must
+// have a piece CID and a valid piece size, as set by SetPieceCommitment.
+func (m GraphsplitManifest) DealProposals(cfg *DealConfig) ([]DealProposal, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("car_deal: %w: configuration is required", ErrInvalidDealConfig)
+	}
+
+	if strings.TrimSpace(cfg.Provider) == "" {
+		return nil, fmt.Errorf("car_deal: %w: provider is required", ErrInvalidDealConfig)
+	}
+
+	if cfg.Duration <= 0 {
+		return nil, fmt.Errorf("car_deal: %w: duration must be positive: %d", ErrInvalidDealConfig, cfg.Duration)
+	}
+
+	if cfg.StartEpoch < 0 || cfg.StoragePrice < 0 {
+		return nil, fmt.Errorf("car_deal: %w: start epoch and storage price must not be negative", ErrInvalidDealConfig)
+	}
+
+	var proposals []DealProposal
+	for _, e := range m.Entries {
+		if e.PieceCID == "" || e.PayloadCID == "" {
+			return nil, fmt.Errorf("car_deal: missing piece or payload CID for %s", e.FileName)
+		}
+
+		if !isPieceSize(e.PieceSize) {
+			return nil, fmt.Errorf("car_deal: %w: %s: %d", ErrInvalidPieceSize, e.PayloadCID, e.PieceSize)
+		}
+
+		p := DealProposal{
+			Provider:           cfg.Provider,
+			PieceCID:           e.PieceCID,
+			PieceSize:          e.PieceSize,
+			CarSize:            e.PayloadSize,
+			PayloadCID:         e.PayloadCID,
+			FileName:           e.CarFileName(),
+			StartEpoch:         cfg.StartEpoch,
+			Duration:           cfg.Duration,
+			StoragePrice:       cfg.StoragePrice,
+			Verified:           cfg.Verified,
+			RemoveUnsealedCopy: cfg.RemoveUnsealedCopy,
+			SkipIPNIAnnounce:   cfg.SkipIPNIAnnounce,
+		}
+
+		if cfg.URLPrefix != "" {
+			p.URL = strings.TrimSuffix(cfg.URLPrefix, "/") + "/" + p.FileName
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals, nil
+}
+
+// WriteDealProposalsJSON writes the proposals to w as a JSON array.
+func WriteDealProposalsJSON(w io.Writer, proposals []DealProposal) error {
+	if proposals == nil {
+		proposals = []DealProposal{}
+	}
+
+	b, err := json.MarshalIndent(proposals, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteDealProposalsCSV writes the proposals to w as CSV with the header row given by DealProposalCSVFields.
+func WriteDealProposalsCSV(w io.Writer, proposals []DealProposal) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(strings.Split(DealProposalCSVFields, ",")); err != nil {
+		return err
+	}
+
+	for _, p := range proposals {
+		startEpoch := ""
+		if p.StartEpoch > 0 {
+			startEpoch = strconv.FormatInt(p.StartEpoch, 10)
+		}
+
+		record := []string{
+			p.Provider,
+			p.PieceCID,
+			strconv.FormatInt(p.PieceSize, 10),
+			strconv.FormatInt(p.CarSize, 10),
+			p.PayloadCID,
+			p.FileName,
+			p.URL,
+			startEpoch,
+			strconv.FormatInt(p.Duration, 10),
+			strconv.FormatInt(p.StoragePrice, 10),
+			strconv.FormatBool(p.Verified),
+			strconv.FormatBool(p.RemoveUnsealedCopy),
+			strconv.FormatBool(p.SkipIPNIAnnounce),
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,290 @@
+package car
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+
+	"github.com/minio/sha256-simd"
+)
+
+const (
+	ReferencesFileName = "references.csv"
+
+	// ReferencesCSVFields lists the columns that follow the entry columns in references.csv and identify the entry that
+	// holds the referenced content.
+	ReferencesCSVFields = "ref_page,ref_path"
+)
+
+// Reference records a file whose content is already archived by another entry, identified by its page index and path,
+// so that the content is not packed again.
+type Reference struct {
+	Entry *cadre.File `json:"entry"`
+	Index int         `json:"page"`
+	Path  string      `json:"path"`
+}
+
+// AddReference adds references to the page. References are written to references.csv and are counted separately from
+// the page's entries.
+func (m *Manifest) AddReference(references ...Reference) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, r := range references {
+		m.references = append(m.references, r)
+		m.metadata.References += 1
+		m.metadata.ReferencesSize += r.Entry.Size
+	}
+}
+
+// References returns an iterator over the references in the page's references.csv, or over the references held by the
+// Manifest if it was not read from disk, has no references.csv, or its references have been read with
+// ReadAllReferences.
+func (m *Manifest) References() iter.Seq2[Reference, error] {
+	return func(yield func(Reference, error) bool) {
+		m.mutex.RLock()
+		fsys, referencesPath, references := m.fsys, m.referencesPath, slices.Clone(m.references)
+		m.mutex.RUnlock()
+
+		if referencesPath == "" {
+			for _, r := range references {
+				if !yield(r, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		for r, err := range readRows(fsys, referencesPath, parseReference) {
+			if !yield(r, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ReadAllReferences reads every reference in the page's references.csv, replacing any references held by the
+// Manifest. From then on, References iterates over the references held by the Manifest rather than over
+// references.csv.
+func (m *Manifest) ReadAllReferences() ([]Reference, error) {
+	m.mutex.RLock()
+	if m.referencesPath == "" {
+		defer m.mutex.RUnlock()
+		return slices.Clone(m.references), nil
+	}
+	m.mutex.RUnlock()
+
+	var references []Reference
+	for r, err := range m.References() {
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, r)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.references, m.referencesPath = references, ""
+	m.metadata.References = len(references)
+	m.metadata.ReferencesSize = 0
+	for _, r := range references {
+		m.metadata.ReferencesSize += r.Entry.Size
+	}
+	return slices.Clone(m.references), nil
+}
+
+// writeReferencesTo writes the provided references to references.csv in the directory dst in fsys and returns its sha256
+// digest. If there are no references, any existing references.csv is removed and the empty string is returned.
+func (m *Manifest) writeReferencesTo(fsys WriteFS, dst string, references []Reference) (string, error) {
+	p := path.Join(dst, ReferencesFileName)
+	if len(references) == 0 {
+		if err := fsys.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		return "", nil
+	}
+
+	h := sha256.New()
+	err := fsys.WriteFile(p, func(w io.Writer) error {
+		columns := entryColumnNames(m.metadata.Columns)
+		writer := csv.NewWriter(io.MultiWriter(w, h))
+		if err := writer.Write(append(slices.Clone(columns), strings.Split(ReferencesCSVFields, ",")...)); err != nil {
+			return err
+		}
+
+		for _, r := range references {
+			if err := writer.Write(append(formatEntry(columns, r.Entry), strconv.Itoa(r.Index), r.Path)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func parseReference(header csvHeader, record []string) (Reference, error) {
+	entry, err := parseEntry(header, record)
+	if err != nil {
+		return Reference{}, err
+	}
+
+	index, err := strconv.Atoi(header.value(record, "ref_page"))
+	if err != nil {
+		return Reference{}, &fieldError{field: header.index("ref_page"), err: err}
+	}
+	return Reference{Entry: entry, Index: index, Path: header.value(record, "ref_path")}, nil
+}
+
+// Deduplicator tracks the sha256 digests of the entries archived for a namespace, so that a file whose content is
+// already archived can be recorded as a Reference instead of being packed again. It is safe for concurrent use.
+type Deduplicator struct {
+	mutex  sync.Mutex
+	digest map[string]Reference
+}
+
+// NewDeduplicator creates a new Deduplicator that has not seen any content.
+func NewDeduplicator() *Deduplicator {
+	return &Deduplicator{digest: make(map[string]Reference)}
+}
+
+// Seed records the entries of existing pages, such as the pages of a Catalog, as archived.
+func (d *Deduplicator) Seed(pages ...*Manifest) error {
+	for _, m := range pages {
+		for entry, err := range m.Entries() {
+			if err != nil {
+				return err
+			}
+			d.Record(m.Index(), entry)
+		}
+	}
+	return nil
+}
+
+// Lookup returns a Reference to the archived entry with the same sha256 digest as f, and whether one was found. Files
+// without a sha256 digest are never matched.
+func (d *Deduplicator) Lookup(f *cadre.File) (Reference, bool) {
+	digest := digestOf(f)
+	if digest == "" {
+		return Reference{}, false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	r, ok := d.digest[digest]
+	if ok {
+		r.Entry = f
+	}
+	return r, ok
+}
+
+// Record records f as archived by the page with the provided index. The first entry recorded for a digest is the one
+// that later references point to.
+func (d *Deduplicator) Record(index int, f *cadre.File) {
+	digest := digestOf(f)
+	if digest == "" {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.digest[digest]; !ok {
+		d.digest[digest] = Reference{Index: index, Path: f.Path}
+	}
+}
+
+// PageDedup summarizes deduplication for a single page.
+//
+// References and BytesSaved count the files recorded as references. Redundant and RedundantBytes count the entries
+// packed by the page whose content was already packed by an earlier entry, which could have been recorded as
+// references.
+type PageDedup struct {
+	Index          int   `json:"page"`
+	Entries        int   `json:"entries"`
+	References     int   `json:"references"`
+	BytesSaved     int64 `json:"bytes_saved"`
+	Redundant      int   `json:"redundant"`
+	RedundantBytes int64 `json:"redundant_bytes"`
+}
+
+// DedupReport summarizes deduplication across the pages of a namespace.
+type DedupReport struct {
+	Namespace      string      `json:"namespace"`
+	Entries        int         `json:"entries"`
+	References     int         `json:"references"`
+	BytesSaved     int64       `json:"bytes_saved"`
+	Redundant      int         `json:"redundant"`
+	RedundantBytes int64       `json:"redundant_bytes"`
+	Pages          []PageDedup `json:"pages"`
+}
+
+// NewDedupReport creates a DedupReport for the provided pages, which are visited in the order given.
+func NewDedupReport(pages ...*Manifest) (*DedupReport, error) {
+	report := &DedupReport{}
+	seen := make(map[string]bool)
+	for _, m := range pages {
+		if report.Namespace == "" {
+			report.Namespace = m.Namespace()
+		}
+
+		md := m.Metadata()
+		page := PageDedup{
+			Index:      md.Index,
+			References: md.References,
+			BytesSaved: md.ReferencesSize,
+		}
+
+		for entry, err := range m.Entries() {
+			if err != nil {
+				return nil, err
+			}
+			page.Entries++
+
+			digest := digestOf(entry)
+			if digest == "" {
+				continue
+			}
+
+			if seen[digest] {
+				page.Redundant++
+				page.RedundantBytes += entry.Size
+			}
+			seen[digest] = true
+		}
+
+		report.Entries += page.Entries
+		report.References += page.References
+		report.BytesSaved += page.BytesSaved
+		report.Redundant += page.Redundant
+		report.RedundantBytes += page.RedundantBytes
+		report.Pages = append(report.Pages, page)
+	}
+	return report, nil
+}
+
+// DedupReport creates a DedupReport for every page in the catalog.
+func (c *Catalog) DedupReport() (*DedupReport, error) {
+	return NewDedupReport(c.pages...)
+}
+
+// String returns a string representation of the DedupReport.
+func (r *DedupReport) String() string {
+	return string(anchor.ToJSONFormatted(r))
+}
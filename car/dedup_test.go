@@ -0,0 +1,163 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduplicatorLookup(t *testing.T) {
+	previous := NewManifest("ns", 0)
+	previous.Add(testEntry("a.txt", "aa", 1))
+	previous.AddReference(Reference{Entry: testEntry("ref.txt", "cc", 3), Index: 1, Path: "c.txt"})
+
+	d := NewDeduplicator()
+	if err := d.Seed(previous); err != nil {
+		t.Fatal(err)
+	}
+	d.Record(2, testEntry("b.txt", "bb", 2))
+	d.Record(3, testEntry("later.txt", "aa", 1))
+
+	tests := []struct {
+		name  string
+		file  string
+		hash  string
+		found bool
+		index int
+		path  string
+	}{
+		{name: "seeded page", file: "copy.txt", hash: "aa", found: true, index: 0, path: "a.txt"},
+		{name: "recorded page", file: "copy.txt", hash: "bb", found: true, index: 2, path: "b.txt"},
+		{name: "unknown digest", file: "new.txt", hash: "dd"},
+		{name: "reference digest", file: "copy.txt", hash: "cc"},
+		{name: "no digest", file: "a.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testEntry(tt.file, tt.hash, 1)
+			r, ok := d.Lookup(f)
+			if ok != tt.found {
+				t.Fatalf("found %t, expected %t", ok, tt.found)
+			}
+
+			if ok && (r.Index != tt.index || r.Path != tt.path || r.Entry != f) {
+				t.Errorf("found %+v, expected page %d and path %s", r, tt.index, tt.path)
+			}
+		})
+	}
+}
+
+func TestNewDedupReport(t *testing.T) {
+	first := NewManifest("ns", 0)
+	first.Add(testEntry("a.txt", "aa", 10), testEntry("b.txt", "bb", 20), testEntry("a2.txt", "aa", 10))
+
+	second := NewManifest("ns", 1)
+	second.Add(testEntry("c.txt", "bb", 20), testEntry("d.txt", "dd", 40))
+	second.AddReference(
+		Reference{Entry: testEntry("ref1.txt", "aa", 10), Index: 0, Path: "a.txt"},
+		Reference{Entry: testEntry("ref2.txt", "dd", 40), Index: 1, Path: "d.txt"})
+
+	report, err := NewDedupReport(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Namespace != "ns" || report.Entries != 5 || report.References != 2 || report.BytesSaved != 50 ||
+		report.Redundant != 2 || report.RedundantBytes != 30 {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	expected := []PageDedup{
+		{Index: 0, Entries: 3, Redundant: 1, RedundantBytes: 10},
+		{Index: 1, Entries: 2, References: 2, BytesSaved: 50, Redundant: 1, RedundantBytes: 20},
+	}
+
+	if len(report.Pages) != len(expected) {
+		t.Fatalf("report has %d pages, expected %d", len(report.Pages), len(expected))
+	}
+
+	for i, page := range report.Pages {
+		if page != expected[i] {
+			t.Errorf("page %d is %+v, expected %+v", i, page, expected[i])
+		}
+	}
+}
+
+func TestCatalogReferences(t *testing.T) {
+	root, _ := writeTestTree(t, testFile{path: "a.txt", size: 100}, testFile{path: "b.txt", size: 200})
+	content, err := os.ReadFile(filepath.Join(root, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "copy.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := t.TempDir()
+	b := NewBuilder("ns", output)
+	b.Dedup = NewDeduplicator()
+	b.MaxEntries = 1
+	pages, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cars := t.TempDir()
+	for _, m := range pages {
+		p := filepath.Join(cars, m.Id()+CarFileExtension)
+		c, err := NewCarWriter().Write(context.Background(), m, root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Rename(p, filepath.Join(cars, c.String()+CarFileExtension)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := OpenCatalog(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.Lookup("copy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Index != 0 || entries[0].Target != "a.txt" ||
+		entries[0].PayloadCID != c.Page(0).PayloadCID() {
+		t.Fatalf("expected copy.txt to resolve to a.txt on page 0, got %v", entries)
+	}
+
+	var extracted bytes.Buffer
+	if err := c.Extract(context.Background(), entries[0], cars, &extracted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(extracted.Bytes(), content) {
+		t.Errorf("extracted %d bytes that differ from the %d bytes of copy.txt", extracted.Len(), len(content))
+	}
+
+	byHash, err := c.LookupHash(digestOf(entries[0].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(byHash) != 2 {
+		t.Errorf("expected a.txt and copy.txt to share a digest, got %v", byHash)
+	}
+
+	report, err := c.DedupReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Entries != 2 || report.References != 1 || report.BytesSaved != int64(len(content)) {
+		t.Errorf("unexpected report: %s", report)
+	}
+}
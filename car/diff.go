@@ -0,0 +1,193 @@
+package car
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+)
+
+// ManifestDiff describes the differences between two sets of Manifest pages for the same namespace.
+//
+// Entries and references are keyed by path, so that a file recorded as a Reference is compared like any other file. An
+// entry whose path is present in both sets is modified if its sha256 (or, if either entry has no sha256, its size)
+// differs. A removed entry whose sha256 matches an added entry is reported as moved rather than as an addition and a
+// removal.
+type ManifestDiff struct {
+	Namespace string        `json:"namespace"`
+	Added     []*cadre.File `json:"added,omitempty"`
+	Removed   []*cadre.File `json:"removed,omitempty"`
+	Modified  []EntryChange `json:"modified,omitempty"`
+	Moved     []EntryChange `json:"moved,omitempty"`
+
+	next uint
+}
+
+// Diff compares the entries and references of the previous and current manifests for a namespace.
+func Diff(previous []*Manifest, current []*Manifest) (*ManifestDiff, error) {
+	d := &ManifestDiff{}
+	before, err := entriesByPath(d, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := entriesByPath(d, current)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range previous {
+		if next := uint(m.Index() + 1); next > d.next {
+			d.next = next
+		}
+	}
+
+	added := make(map[string][]*cadre.File)
+	for _, p := range sortedPaths(after) {
+		entry := after[p]
+		prev, ok := before[p]
+		if !ok {
+			if sha256 := digestOf(entry); sha256 != "" {
+				added[sha256] = append(added[sha256], entry)
+			}
+			continue
+		}
+
+		if modified(prev, entry) {
+			d.Modified = append(d.Modified, EntryChange{Entry: prev, File: entry})
+		}
+	}
+
+	moved := make(map[string]bool)
+	for _, p := range sortedPaths(before) {
+		entry := before[p]
+		if _, ok := after[p]; ok {
+			continue
+		}
+
+		if candidates := added[digestOf(entry)]; len(candidates) > 0 {
+			d.Moved = append(d.Moved, EntryChange{Entry: entry, File: candidates[0]})
+			added[digestOf(entry)] = candidates[1:]
+			moved[entryName(candidates[0].Path)] = true
+			continue
+		}
+		d.Removed = append(d.Removed, entry)
+	}
+
+	for p, entry := range after {
+		if _, ok := before[p]; !ok && !moved[p] {
+			d.Added = append(d.Added, entry)
+		}
+	}
+
+	sortEntries(d.Added)
+	sortEntries(d.Removed)
+	sortChanges(d.Modified)
+	sortChanges(d.Moved)
+	return d, nil
+}
+
+// Builder returns a Builder for delta pages whose first page index continues the index sequence of the previous
+// manifests.
+func (d *ManifestDiff) Builder(output string) *Builder {
+	b := NewBuilder(d.Namespace, output)
+	b.Index = d.next
+	return b
+}
+
+// Delta returns the added, modified and moved entries, i.e. the entries that the delta pages must list. Moved entries
+// are listed under their new paths; a Builder with a Deduplicator seeded with the previous pages records them as
+// references rather than packing their content again.
+func (d *ManifestDiff) Delta() []*cadre.File {
+	delta := make([]*cadre.File, 0, len(d.Added)+len(d.Modified)+len(d.Moved))
+	delta = append(delta, d.Added...)
+	for _, c := range d.Modified {
+		delta = append(delta, c.File)
+	}
+
+	for _, c := range d.Moved {
+		delta = append(delta, c.File)
+	}
+	return delta
+}
+
+// IsEmpty returns whether the compared manifest sets list the same entries.
+func (d *ManifestDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && len(d.Moved) == 0
+}
+
+// NextIndex returns the index following the last page of the previous manifests.
+func (d *ManifestDiff) NextIndex() uint {
+	return d.next
+}
+
+// Pages pages the entries returned by Delta using the provided Builder, which is typically created by
+// ManifestDiff.Builder.
+func (d *ManifestDiff) Pages(b *Builder) ([]*Manifest, error) {
+	if err := b.Add(d.Delta()...); err != nil {
+		return nil, err
+	}
+
+	if err := b.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Manifests(), nil
+}
+
+// String returns a string representation of the ManifestDiff.
+func (d *ManifestDiff) String() string {
+	return string(anchor.ToJSONFormatted(d))
+}
+
+func entriesByPath(d *ManifestDiff, manifests []*Manifest) (map[string]*cadre.File, error) {
+	entries := make(map[string]*cadre.File)
+	for _, m := range manifests {
+		if d.Namespace == "" {
+			d.Namespace = m.Namespace()
+		}
+
+		for entry, err := range m.Entries() {
+			if err != nil {
+				return nil, err
+			}
+			entries[entryName(entry.Path)] = entry
+		}
+
+		for r, err := range m.References() {
+			if err != nil {
+				return nil, err
+			}
+			entries[entryName(r.Entry.Path)] = r.Entry
+		}
+	}
+	return entries, nil
+}
+
+func sortedPaths[V any](entries map[string]V) []string {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func digestOf(f *cadre.File) string {
+	return strings.ToLower(f.HashOf("sha256"))
+}
+
+func modified(prev *cadre.File, entry *cadre.File) bool {
+	if a, b := digestOf(prev), digestOf(entry); a != "" && b != "" {
+		return a != b
+	}
+	return prev.Size != entry.Size
+}
+
+func sortEntries(entries []*cadre.File) {
+	sort.Slice(entries, func(i int, j int) bool { return entries[i].Path < entries[j].Path })
+}
+
+func sortChanges(changes []EntryChange) {
+	sort.Slice(changes, func(i int, j int) bool { return changes[i].File.Path < changes[j].File.Path })
+}
@@ -0,0 +1,112 @@
+package car
+
+import (
+	"testing"
+
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+)
+
+func testEntry(p string, sha256 string, size int64) *cadre.File {
+	return &cadre.File{Name: p, Path: p, Size: size, Hash: &ecs.Hash{Sha256: sha256}}
+}
+
+func TestDiffMoved(t *testing.T) {
+	previous := NewManifest("ns", 0)
+	previous.Add(testEntry("a.txt", "aa", 1), testEntry("old.txt", "bb", 2))
+
+	current := NewManifest("ns", 0)
+	current.Add(testEntry("a.txt", "aa", 1), testEntry("new.txt", "bb", 2))
+
+	d, err := Diff([]*Manifest{previous}, []*Manifest{current})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Moved) != 1 || len(d.Added) != 0 || len(d.Removed) != 0 {
+		t.Fatalf("expected a single move: %s", d)
+	}
+
+	delta := d.Delta()
+	if len(delta) != 1 || delta[0].Path != "new.txt" {
+		t.Fatalf("expected new.txt in the delta, got %v", delta)
+	}
+
+	b := d.Builder("")
+	b.Dedup = NewDeduplicator()
+	if err := b.Dedup.Seed(previous); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := d.Pages(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	references, err := pages[0].ReadAllReferences()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 1 || pages[0].Index() != 1 || len(references) != 1 || references[0].Entry.Path != "new.txt" {
+		t.Fatalf("expected new.txt to be recorded as a reference on page 1: %v", pages)
+	}
+}
+
+func TestDiffReferences(t *testing.T) {
+	previous := NewManifest("ns", 0)
+	previous.Add(testEntry("a.txt", "aa", 1))
+	previous.AddReference(Reference{Entry: testEntry("copy.txt", "aa", 1), Index: 0, Path: "a.txt"})
+
+	tests := []struct {
+		name    string
+		current func() *Manifest
+		removed []string
+	}{
+		{
+			name: "reference becomes an entry",
+			current: func() *Manifest {
+				m := NewManifest("ns", 0)
+				m.Add(testEntry("a.txt", "aa", 1), testEntry("copy.txt", "aa", 1))
+				return m
+			},
+		},
+		{
+			name: "reference is unchanged",
+			current: func() *Manifest {
+				m := NewManifest("ns", 0)
+				m.Add(testEntry("a.txt", "aa", 1))
+				m.AddReference(Reference{Entry: testEntry("copy.txt", "aa", 1), Index: 0, Path: "a.txt"})
+				return m
+			},
+		},
+		{
+			name: "reference is removed",
+			current: func() *Manifest {
+				m := NewManifest("ns", 0)
+				m.Add(testEntry("a.txt", "aa", 1))
+				return m
+			},
+			removed: []string{"copy.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Diff([]*Manifest{previous}, []*Manifest{tt.current()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(d.Added) != 0 || len(d.Modified) != 0 || len(d.Moved) != 0 || len(d.Removed) != len(tt.removed) {
+				t.Fatalf("unexpected diff: %s", d)
+			}
+
+			for i, p := range tt.removed {
+				if d.Removed[i].Path != p {
+					t.Errorf("removed %s, expected %s", d.Removed[i].Path, p)
+				}
+			}
+		})
+	}
+}
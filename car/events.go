@@ -0,0 +1,95 @@
+package car
+
+import (
+	"io/fs"
+	"iter"
+	"path"
+	"strconv"
+
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+	"github.com/transientvariable/cadre/storage"
+)
+
+// Keys of the labels added to the storage events returned by Manifest.Events.
+const (
+	EventLabelPage        = "page"
+	EventLabelPayloadCIDs = "payload_cids"
+	EventLabelPieceCIDs   = "piece_cids"
+)
+
+// Events returns an iterator over storage events describing the page: a creation event for the page itself, followed
+// by a creation event for each entry returned by Entries. Every event is labelled with the page index.
+//
+// The page event describes the page as a directory named after the page under the namespace, and its labels hold the
+// payload and piece CIDs of the graphsplit rows recorded for the page, or the payload CID recorded by CarWriter if the
+// page has no graphsplit rows. Labels without CIDs are omitted. Entries are copied, so the page's entries are not
+// modified by the events.
+func (m *Manifest) Events() iter.Seq2[*storage.Event, error] {
+	return func(yield func(*storage.Event, error) bool) {
+		md := m.Metadata()
+		event, err := storage.NewStorageEvent(ecs.EventTypeCreation, md.Namespace, m.pageFile())
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		var payloadCIDs, pieceCIDs []string
+		for _, row := range m.Graphsplit().Entries {
+			payloadCIDs = append(payloadCIDs, row.PayloadCID)
+			pieceCIDs = append(pieceCIDs, row.PieceCID)
+		}
+
+		if len(payloadCIDs) == 0 && md.PayloadCID != "" {
+			payloadCIDs = []string{md.PayloadCID}
+		}
+
+		event.Labels[EventLabelPage] = md.Index
+		if len(payloadCIDs) > 0 {
+			event.Labels[EventLabelPayloadCIDs] = payloadCIDs
+		}
+
+		if len(pieceCIDs) > 0 {
+			event.Labels[EventLabelPieceCIDs] = pieceCIDs
+		}
+
+		if !yield(event, nil) {
+			return
+		}
+
+		for entry, err := range m.Entries() {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if entry, err = m.copyEntry(entry); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			event, err := storage.NewStorageEvent(ecs.EventTypeCreation, md.Namespace, entry)
+			if err == nil {
+				event.Labels[EventLabelPage] = md.Index
+			}
+
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// pageFile returns a cadre.File describing the page as a directory named after the page index under the namespace.
+func (m *Manifest) pageFile() *cadre.File {
+	md := m.Metadata()
+	id := pageID(md.Index)
+	return &cadre.File{
+		Directory: md.Namespace,
+		Mode:      strconv.Itoa(int(fs.ModeDir | 0755)),
+		Name:      id,
+		Path:      path.Join(md.Namespace, id),
+		Size:      md.Size,
+		Type:      "directory",
+	}
+}
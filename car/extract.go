@@ -0,0 +1,375 @@
+package car
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/minio/sha256-simd"
+	"github.com/multiformats/go-multihash"
+)
+
+var ErrContentDigestMismatch = errors.New("extracted content does not match the sha256 digest recorded for the entry")
+
+// ExtractFile writes the content of the file at path p in the UnixFS DAG of the CAR file at carPath to w and returns
+// the number of bytes written.
+//
+// Only the blocks on the path to the file and the blocks of the file itself are read. The path is resolved from the
+// CAR's root directory; if it is not found there, the file whose path ends with p is used, provided that exactly one
+// does, so that paths recorded relative to a subdirectory of the DAG root can be resolved.
+func ExtractFile(ctx context.Context, carPath string, p string, w io.Writer) (int64, error) {
+	blocks, err := openCarBlocks(carPath)
+	if err != nil {
+		return 0, fmt.Errorf("car_extract: %w", err)
+	}
+	defer func(blocks *carBlocks) {
+		if err := blocks.close(); err != nil {
+			fmt.Println(fmt.Errorf("car_extract: %w", err))
+		}
+	}(blocks)
+
+	c, err := blocks.resolve(ctx, p)
+	if err != nil {
+		return 0, fmt.Errorf("car_extract: %w", err)
+	}
+
+	cw := &countingWriter{w: w}
+	if err := blocks.writeFile(ctx, c, cw); err != nil {
+		return cw.n, fmt.Errorf("car_extract: %w", err)
+	}
+	return cw.n, nil
+}
+
+// Extract writes the content of the file recorded by the catalog entry to w, reading it from the CAR files in dir, and
+// checks it against the size and sha256 digest recorded in entries.csv.
+//
+// A file that graphsplit split across CARs is reassembled from each of its parts in the order in which the page's
+// manifest.csv lists them. If the content does not match, ErrSizeMismatch or ErrContentDigestMismatch is returned
+// after the content has been written, so w should be discarded on error.
+func (c *Catalog) Extract(ctx context.Context, entry CatalogEntry, dir string, w io.Writer) error {
+	if err := c.index(); err != nil {
+		return err
+	}
+
+	var parts []CatalogEntry
+	for _, ce := range c.byPath[entryName(entry.File.Path)] {
+		if ce.Index == entry.Index && ce.Target == entry.Target {
+			parts = append(parts, ce)
+		}
+	}
+
+	if len(parts) == 0 {
+		parts = []CatalogEntry{entry}
+	}
+
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	for _, part := range parts {
+		if err := extractPart(ctx, part, dir, len(parts) == 1, cw); err != nil {
+			return fmt.Errorf("car_extract: %s: %w", entry.File.Path, err)
+		}
+	}
+
+	if cw.n != entry.File.Size {
+		return fmt.Errorf("car_extract: %w: %s: expected %d bytes, extracted %d", ErrSizeMismatch, entry.File.Path,
+			entry.File.Size, cw.n)
+	}
+
+	if expected := digestOf(entry.File); expected != "" {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+			return fmt.Errorf("car_extract: %w: %s: expected %s, extracted %s", ErrContentDigestMismatch,
+				entry.File.Path, expected, actual)
+		}
+	}
+	return nil
+}
+
+// extractPart writes the part of a file held by the CAR file for the catalog entry to w. The CID recorded for the part
+// by its graphsplit row, or for the entry if the CAR holds the whole file, is used when the CAR contains it; otherwise
+// the file is resolved by its path.
+func extractPart(ctx context.Context, ce CatalogEntry, dir string, whole bool, w io.Writer) error {
+	carPath, err := GraphsplitManifestEntry{PayloadCID: ce.PayloadCID, FileName: ce.FileName}.CarPath(dir)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := openCarBlocks(carPath)
+	if err != nil {
+		return err
+	}
+	defer func(blocks *carBlocks) {
+		if err := blocks.close(); err != nil {
+			fmt.Println(fmt.Errorf("car_extract: %w", err))
+		}
+	}(blocks)
+
+	recorded := ce.CID
+	if recorded == "" && whole {
+		recorded = ce.File.CID
+	}
+
+	var root cid.Cid
+	if fc, err := cid.Decode(recorded); err == nil && blocks.has(fc) {
+		root = fc
+	} else {
+		p := ce.Target
+		if p == "" {
+			p = ce.File.Path
+		}
+
+		if root, err = blocks.resolve(ctx, p); err != nil {
+			return err
+		}
+	}
+	return blocks.writeFile(ctx, root, w)
+}
+
+// carBlocks provides random access to the blocks of a CAR file by CID.
+type carBlocks struct {
+	reader   *carFileReader
+	sections map[string]int64
+}
+
+func openCarBlocks(path string) (*carBlocks, error) {
+	reader, err := openCarFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := reader.sections()
+	if err != nil {
+		_ = reader.close()
+		return nil, err
+	}
+	return &carBlocks{reader: reader, sections: sections}, nil
+}
+
+func (b *carBlocks) has(c cid.Cid) bool {
+	_, ok := b.sections[string(c.Hash())]
+	return ok || c.Prefix().MhType == multihash.IDENTITY
+}
+
+// get returns the data of the block for the CID, checking that it matches the CID. Blocks with an identity multihash
+// are decoded from the CID itself.
+func (b *carBlocks) get(c cid.Cid) ([]byte, error) {
+	var data []byte
+	if c.Prefix().MhType == multihash.IDENTITY {
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return nil, err
+		}
+		data = dmh.Digest
+	} else {
+		offset, ok := b.sections[string(c.Hash())]
+		if !ok {
+			return nil, fmt.Errorf("block %s is not present in the CAR file", c)
+		}
+
+		var err error
+		if _, data, err = b.reader.section(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sum.Equals(c) {
+		return nil, fmt.Errorf("block data does not match CID %s", c)
+	}
+	return data, nil
+}
+
+// node returns the decoded dag-pb node and UnixFS data for the CID. A raw block is returned as a UnixFS raw node
+// holding the block data.
+func (b *carBlocks) node(c cid.Cid) (pbNode, unixfsData, error) {
+	data, err := b.get(c)
+	if err != nil {
+		return pbNode{}, unixfsData{}, err
+	}
+
+	switch c.Type() {
+	case cid.Raw:
+		return pbNode{}, unixfsData{typ: unixfsRaw, data: data}, nil
+	case cid.DagProtobuf:
+		pn, err := unmarshalPBNode(data)
+		if err != nil {
+			return pbNode{}, unixfsData{}, err
+		}
+
+		ud, err := unmarshalUnixfsData(pn.data)
+		if err != nil {
+			return pbNode{}, unixfsData{}, err
+		}
+		return pn, ud, nil
+	}
+	return pbNode{}, unixfsData{}, fmt.Errorf("%w: unsupported codec 0x%x for %s", ErrInvalidNode, c.Type(), c)
+}
+
+// resolve returns the CID of the file at path p.
+func (b *carBlocks) resolve(ctx context.Context, p string) (cid.Cid, error) {
+	if len(b.reader.roots) == 0 {
+		return cid.Undef, fmt.Errorf("%w: no roots", ErrInvalidCar)
+	}
+
+	p = entryName(p)
+	c := b.reader.roots[0]
+	found := true
+	for _, name := range strings.Split(p, "/") {
+		if err := ctx.Err(); err != nil {
+			return cid.Undef, err
+		}
+
+		next, ok, err := b.child(c, name)
+		if err != nil {
+			return cid.Undef, err
+		}
+
+		if !ok {
+			found = false
+			break
+		}
+		c = next
+	}
+
+	if found {
+		return c, nil
+	}
+
+	var matches []cid.Cid
+	err := b.walkFiles(ctx, b.reader.roots[0], "", func(fp string, fc cid.Cid) {
+		if fp == p || strings.HasSuffix(fp, "/"+p) {
+			matches = append(matches, fc)
+		}
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if len(matches) != 1 {
+		return cid.Undef, fmt.Errorf("%w: %s", fs.ErrNotExist, p)
+	}
+	return matches[0], nil
+}
+
+// child returns the CID of the entry with the provided name in the directory or HAMT shard for the CID.
+func (b *carBlocks) child(c cid.Cid, name string) (cid.Cid, bool, error) {
+	pn, ud, err := b.node(c)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+
+	switch ud.typ {
+	case unixfsDirectory:
+		for _, l := range pn.links {
+			if l.name == name {
+				return l.hash, true, nil
+			}
+		}
+	case unixfsHAMTShard:
+		width := len(strconv.FormatUint(max(ud.fanout, 2)-1, 16))
+		for _, l := range pn.links {
+			if len(l.name) <= width {
+				if next, ok, err := b.child(l.hash, name); err != nil || ok {
+					return next, ok, err
+				}
+				continue
+			}
+
+			if l.name[width:] == name {
+				return l.hash, true, nil
+			}
+		}
+	}
+	return cid.Undef, false, nil
+}
+
+// walkFiles calls fn with the path and CID of every file under the directory for the CID. Only the root blocks of
+// files are read.
+func (b *carBlocks) walkFiles(ctx context.Context, c cid.Cid, p string, fn func(p string, c cid.Cid)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pn, ud, err := b.node(c)
+	if err != nil {
+		return err
+	}
+
+	switch ud.typ {
+	case unixfsFile, unixfsRaw:
+		fn(p, c)
+	case unixfsDirectory:
+		for _, l := range pn.links {
+			if err := b.walkFiles(ctx, l.hash, path.Join(p, l.name), fn); err != nil {
+				return err
+			}
+		}
+	case unixfsHAMTShard:
+		width := len(strconv.FormatUint(max(ud.fanout, 2)-1, 16))
+		for _, l := range pn.links {
+			next := p
+			if len(l.name) > width {
+				next = path.Join(p, l.name[width:])
+			}
+
+			if err := b.walkFiles(ctx, l.hash, next, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile writes the content of the UnixFS file DAG for the CID to w, one block at a time.
+func (b *carBlocks) writeFile(ctx context.Context, c cid.Cid, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pn, ud, err := b.node(c)
+	if err != nil {
+		return err
+	}
+
+	if ud.typ != unixfsFile && ud.typ != unixfsRaw {
+		return fmt.Errorf("%w: %s is not a file", ErrInvalidNode, c)
+	}
+
+	if len(ud.data) > 0 {
+		if _, err := w.Write(ud.data); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range pn.links {
+		if err := b.writeFile(ctx, l.hash, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *carBlocks) close() error {
+	return b.reader.close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,371 @@
+package car
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrReadOnlyFS = errors.New("filesystem is not writable")
+
+// WriteFS is a filesystem that manifest pages can be written to. As for fs.FS, names are unrooted, slash-separated
+// paths.
+type WriteFS interface {
+	fs.FS
+
+	// MkdirAll creates the named directory along with any parents that do not exist.
+	MkdirAll(name string, perm fs.FileMode) error
+
+	// Remove removes the named file.
+	Remove(name string) error
+
+	// WriteFile replaces the named file with the content written by write. A reader must observe either the previous
+	// content of the file or the complete new content.
+	WriteFile(name string, write func(w io.Writer) error) error
+}
+
+// pageLocker is implemented by filesystems that hold a lock on a page directory while the page is written.
+type pageLocker interface {
+	lockPage(dir string) (*pageLock, error)
+}
+
+// DirFS returns a WriteFS for the directory tree rooted at dir. Files are replaced atomically, and page directories are
+// locked while pages are written, so that processes writing the same page are serialized.
+func DirFS(dir string) WriteFS {
+	return dirFS{FS: os.DirFS(dir), root: dir}
+}
+
+type dirFS struct {
+	fs.FS
+	root string
+}
+
+func (d dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := d.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+func (d dirFS) Remove(name string) error {
+	p, err := d.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (d dirFS) WriteFile(name string, write func(w io.Writer) error) error {
+	p, err := d.join("write", name)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, write)
+}
+
+func (d dirFS) lockPage(dir string) (*pageLock, error) {
+	p, err := d.join("lock", dir)
+	if err != nil {
+		return nil, err
+	}
+	return lockPage(p)
+}
+
+func (d dirFS) join(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// MemFS is an in-memory WriteFS, e.g. for testing manifest tooling without touching disk. It is safe for concurrent
+// use by multiple goroutines.
+type MemFS struct {
+	files map[string]*memFile
+	mutex sync.RWMutex
+}
+
+// NewMemFS creates a new, empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFile)}
+}
+
+// Open opens the named file or directory. A file that is open keeps its content if the file is later rewritten.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	f, ok := m.files[name]
+	if name == "." {
+		f, ok = &memFile{mode: fs.ModeDir | 0755}, true
+	}
+
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	info := memFileInfo{name: path.Base(name), file: f}
+	if !f.mode.IsDir() {
+		return &openMemFile{Reader: bytes.NewReader(f.data), info: info}, nil
+	}
+
+	var entries []fs.DirEntry
+	for p, child := range m.files {
+		if p != "." && path.Dir(p) == name {
+			entries = append(entries, memFileInfo{name: path.Base(p), file: child})
+		}
+	}
+	sort.Slice(entries, func(i int, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &openMemDir{entries: entries, info: info}, nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for p := name; p != "."; p = path.Dir(p) {
+		if f, ok := m.files[p]; ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+			}
+			continue
+		}
+		m.files[p] = &memFile{mode: fs.ModeDir | perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) WriteFile(name string, write func(w io.Writer) error) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var b bytes.Buffer
+	if err := write(&b); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if dir := path.Dir(name); dir != "." {
+		if f, ok := m.files[dir]; !ok || !f.mode.IsDir() {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	if f, ok := m.files[name]; ok && f.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	m.files[name] = &memFile{data: b.Bytes(), mode: 0644, modTime: time.Now()}
+	return nil
+}
+
+// memFile is a file or directory held by a MemFS. Its fields are never modified once it has been added.
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// memFileInfo describes a memFile as both an fs.FileInfo and an fs.DirEntry.
+type memFileInfo struct {
+	name string
+	file *memFile
+}
+
+func (i memFileInfo) Name() string {
+	return i.name
+}
+
+func (i memFileInfo) Size() int64 {
+	return int64(len(i.file.data))
+}
+
+func (i memFileInfo) Mode() fs.FileMode {
+	return i.file.mode
+}
+
+func (i memFileInfo) Type() fs.FileMode {
+	return i.file.mode.Type()
+}
+
+func (i memFileInfo) ModTime() time.Time {
+	return i.file.modTime
+}
+
+func (i memFileInfo) IsDir() bool {
+	return i.file.mode.IsDir()
+}
+
+func (i memFileInfo) Sys() any {
+	return nil
+}
+
+func (i memFileInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+// openMemFile is a regular file opened from a MemFS.
+type openMemFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f *openMemFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *openMemFile) Close() error {
+	return nil
+}
+
+// openMemDir is a directory opened from a MemFS, listing the entries it held when it was opened.
+type openMemDir struct {
+	entries []fs.DirEntry
+	info    memFileInfo
+	offset  int
+}
+
+func (d *openMemDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *openMemDir) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *openMemDir) Close() error {
+	return nil
+}
+
+// ReadDir returns up to n of the remaining directory entries, or all of them if n <= 0, as specified by
+// fs.ReadDirFile.
+func (d *openMemDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// displayPath returns the path of the named file in fsys for use in messages, which is the path on disk for a DirFS.
+func displayPath(fsys fs.FS, name string) string {
+	if d, ok := fsys.(dirFS); ok {
+		return filepath.Join(d.root, filepath.FromSlash(name))
+	}
+	return name
+}
+
+// writeFileAtomic writes the file at path using the provided write function so that a reader observes either the
+// previous content of the file or the complete new content, even if the process crashes part way through.
+//
+// The content is written to a temporary file in the same directory, which is fsynced and renamed over path, after which
+// the directory itself is fsynced so that the rename is durable.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriterSize(tmp, tokenBufferSize)
+	if err := write(bw); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	committed = true
+	return syncDir(dir)
+}
+
+// syncDir fsyncs the directory so that entries created or renamed in it are durable. Directories cannot be fsynced on
+// Windows, so it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if cerr := d.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("syncing directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package car
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"path"
+	"testing"
+	"testing/fstest"
+)
+
+func writeMemFile(t *testing.T, fsys *MemFS, name string, content string) {
+	t.Helper()
+
+	if err := fsys.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := fsys.WriteFile(name, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := NewMemFS()
+	writeMemFile(t, fsys, "a.txt", "a")
+	writeMemFile(t, fsys, "dir/b.txt", "bb")
+	writeMemFile(t, fsys, "dir/sub/c.txt", "ccc")
+
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSCatalog(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := NewMemFS()
+	writeMemFile(t, src, "a.txt", "a")
+	writeMemFile(t, src, "dir/b.txt", "bb")
+	writeMemFile(t, src, "dir/copy.txt", "a")
+	writeMemFile(t, src, "dir/sub/c.txt", "ccc")
+
+	b := NewBuilder("ns", "")
+	b.MaxEntries = 2
+	b.Dedup = NewDeduplicator()
+	pages, err := b.BuildFS(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := NewMemFS()
+	for _, m := range pages {
+		m.SetSigningKey(private)
+		if err := m.WriteToFS(out, "."); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := OpenCatalogFS(out, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Pages()) != 2 || c.Metadata().Entries != 3 {
+		t.Fatalf("expected 3 entries on 2 pages: %s", c)
+	}
+
+	for _, p := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		entries, err := c.Lookup(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 || entries[0].File.Path != p || entries[0].File.Size == 0 {
+			t.Errorf("lookup of %s returned %v", p, entries)
+		}
+	}
+
+	entries, err := c.Lookup("dir/copy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Target != "a.txt" {
+		t.Errorf("expected dir/copy.txt to refer to a.txt, got %v", entries)
+	}
+}
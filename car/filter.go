@@ -0,0 +1,198 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/cadre"
+)
+
+var ErrInvalidFilter = errors.New("invalid entry filter")
+
+// filterHashes lists the digest names accepted by EntryFilter.Hashes.
+var filterHashes = []string{"adler", "adler32", "md5", "sha1", "sha256", "sha512", "ssdeep"}
+
+// EntryFilter selects manifest entries. An entry is selected if it matches every criterion that is set; the zero value
+// selects every entry.
+//
+// Paths are glob patterns in the syntax of path.Match matched against the entry path, where a "**" segment matches any
+// number of directories. A pattern without a slash is matched against the file name instead. An entry matches if it
+// matches any of the patterns.
+//
+// MinSize and MaxSize bound the entry size inclusively, and a zero MaxSize leaves it unbounded. ModifiedAfter and
+// ModifiedBefore select entries whose mtime is at or after ModifiedAfter and before ModifiedBefore; entries without an
+// mtime never match a window. Extensions are compared case-insensitively with or without the leading dot. Hashes names
+// the digests, e.g. "sha256", that an entry must have recorded.
+type EntryFilter struct {
+	Paths          []string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Extensions     []string
+	Hashes         []string
+}
+
+// Validate checks that the patterns and digest names in the filter are valid.
+func (f EntryFilter) Validate() error {
+	for _, p := range f.Paths {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidFilter, p, err)
+		}
+	}
+
+	if f.MinSize < 0 || f.MaxSize < 0 || (f.MaxSize > 0 && f.MinSize > f.MaxSize) {
+		return fmt.Errorf("%w: invalid size range %d-%d", ErrInvalidFilter, f.MinSize, f.MaxSize)
+	}
+
+	for _, h := range f.Hashes {
+		if !slices.Contains(filterHashes, strings.ToLower(strings.TrimSpace(h))) {
+			return fmt.Errorf("%w: unknown digest %s", ErrInvalidFilter, h)
+		}
+	}
+	return nil
+}
+
+// Match returns whether the entry matches the filter.
+func (f EntryFilter) Match(e *cadre.File) bool {
+	if len(f.Paths) > 0 && !slices.ContainsFunc(f.Paths, func(p string) bool { return matchGlob(p, e.Path) }) {
+		return false
+	}
+
+	if e.Size < f.MinSize || (f.MaxSize > 0 && e.Size > f.MaxSize) {
+		return false
+	}
+
+	if !f.ModifiedAfter.IsZero() || !f.ModifiedBefore.IsZero() {
+		if e.Mtime == nil || e.Mtime.IsZero() {
+			return false
+		}
+
+		if !f.ModifiedAfter.IsZero() && e.Mtime.Before(f.ModifiedAfter) {
+			return false
+		}
+
+		if !f.ModifiedBefore.IsZero() && !e.Mtime.Before(f.ModifiedBefore) {
+			return false
+		}
+	}
+
+	if len(f.Extensions) > 0 {
+		ext := e.Extension
+		if ext == "" {
+			ext = path.Ext(e.Name)
+		}
+
+		if !slices.ContainsFunc(f.Extensions, func(x string) bool {
+			return strings.EqualFold(strings.TrimPrefix(x, "."), strings.TrimPrefix(ext, "."))
+		}) {
+			return false
+		}
+	}
+
+	for _, h := range f.Hashes {
+		if e.HashOf(h) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns a new Manifest with the same namespace, index and columns as the page that holds the entries and
+// references matching the filter. The new Manifest has no payload CID or graphsplit manifest, since the page's CARs
+// do not correspond to the subset, and can be written with WriteTo.
+func (m *Manifest) Filter(f EntryFilter) (*Manifest, error) {
+	md := m.Metadata()
+	return filterPages(f, md.Namespace, uint(md.Index), md.Columns, m)
+}
+
+// Filter returns a new Manifest with the provided index that holds the entries and references matching the filter
+// across every page in the catalog. The columns of the new Manifest are the union of the columns of the pages.
+func (c *Catalog) Filter(f EntryFilter, index uint) (*Manifest, error) {
+	var namespace string
+	var columns []string
+	for _, m := range c.pages {
+		md := m.Metadata()
+		if namespace == "" {
+			namespace = md.Namespace
+		}
+
+		for _, column := range md.Columns {
+			if !slices.Contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return filterPages(f, namespace, index, columns, c.pages...)
+}
+
+func filterPages(f EntryFilter, namespace string, index uint, columns []string, pages ...*Manifest) (*Manifest, error) {
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+
+	filtered := NewManifest(namespace, index)
+	if err := filtered.SetColumns(columns...); err != nil {
+		return nil, err
+	}
+
+	for _, m := range pages {
+		for entry, err := range m.Entries() {
+			if err != nil {
+				return nil, err
+			}
+
+			if f.Match(entry) {
+				filtered.Add(entry)
+			}
+		}
+
+		for r, err := range m.References() {
+			if err != nil {
+				return nil, err
+			}
+
+			if f.Match(r.Entry) {
+				filtered.AddReference(r)
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// matchGlob reports whether the entry path p matches the glob pattern, which must be valid.
+func matchGlob(pattern string, p string) bool {
+	p = entryName(p)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -2,8 +2,13 @@ package car
 
 import (
 	"bufio"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -14,17 +19,16 @@ import (
 	"github.com/ipfs/go-cid"
 
 	json "github.com/json-iterator/go"
-	pool "github.com/libp2p/go-buffer-pool"
 )
 
 const (
 	tokenBufferSize = anchor.MiB
-	tokenSizeMax    = 10 * anchor.MiB
 
 	GraphsplitManifestFileName = "manifest.csv"
-)
 
-var tokenBufferPool pool.BufferPool
+	// GraphsplitCSVFields is the header row written by graphsplit, including its misspelling of payload_cid.
+	GraphsplitCSVFields = "playload_cid,filename,piece_cid,payload_size,piece_size,detail"
+)
 
 type GraphsplitManifestEntry struct {
 	FileName    string `json:"file_name"`
@@ -34,6 +38,8 @@ type GraphsplitManifestEntry struct {
 	PieceCID    string `json:"piece_cid"`
 	PieceHash   string `json:"piece_hash"`
 	PieceSize   int64  `json:"piece_size"`
+
+	Detail *GraphsplitNode `json:"detail,omitempty"`
 }
 
 func (e GraphsplitManifestEntry) ToMap() (map[string]any, error) {
@@ -48,11 +54,31 @@ func (e GraphsplitManifestEntry) String() string {
 	return string(anchor.ToJSONFormatted(e))
 }
 
+// GraphsplitNode is a node in the UnixFS tree recorded in the detail column of manifest.csv. The field names match the
+// JSON produced by graphsplit.
+type GraphsplitNode struct {
+	Name string
+	Hash string
+	Size uint64
+	Link []GraphsplitNode
+}
+
 type GraphsplitManifest struct {
 	File    cadre.File
 	Entries []GraphsplitManifestEntry
 }
 
+// GraphsplitFile is an entry from entries.csv annotated with the graphsplit row for the CAR that holds it. CID is the
+// CID of the file in that CAR as recorded by the row's detail tree. CID, FileName, PayloadCID and PieceCID are empty if
+// no row could be matched to the entry.
+type GraphsplitFile struct {
+	File       *cadre.File `json:"file"`
+	CID        string      `json:"cid,omitempty"`
+	FileName   string      `json:"file_name,omitempty"`
+	PayloadCID string      `json:"payload_cid,omitempty"`
+	PieceCID   string      `json:"piece_cid,omitempty"`
+}
+
 func NewGraphsplitManifest(path string) (GraphsplitManifest, error) {
 	path = strings.TrimSpace(path)
 
@@ -61,27 +87,42 @@ func NewGraphsplitManifest(path string) (GraphsplitManifest, error) {
 		return GraphsplitManifest{}, err
 	}
 
-	manifestFile := cadre.File{Path: path}
 	if mp.IsDir() {
-		manifestFile.Directory = path
-		manifestFile.Name = GraphsplitManifestFileName
-		manifestFile.Path = filepath.Join(path, GraphsplitManifestFileName)
-	} else {
-		manifestFile.Directory = filepath.Dir(path)
-		manifestFile.Name = filepath.Base(path)
+		return NewGraphsplitManifestFS(DirFS(path), ".")
+	}
+
+	return NewGraphsplitManifestFS(DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+// NewGraphsplitManifestFS reads the named graphsplit manifest in fsys. If name is a directory, its manifest.csv is
+// read.
+func NewGraphsplitManifestFS(fsys fs.FS, name string) (GraphsplitManifest, error) {
+	mp, err := fs.Stat(fsys, name)
+	if err != nil {
+		return GraphsplitManifest{}, err
+	}
+
+	if mp.IsDir() {
+		name = path.Join(name, GraphsplitManifestFileName)
+	}
+
+	manifestFile := cadre.File{
+		Directory: displayPath(fsys, path.Dir(name)),
+		Name:      path.Base(name),
+		Path:      displayPath(fsys, name),
 	}
 
-	mf, err := os.Open(manifestFile.Path)
+	mf, err := fsys.Open(name)
 	if err != nil {
 		return GraphsplitManifest{}, err
 	}
-	defer func(f *os.File) {
+	defer func(f fs.File) {
 		if err := f.Close(); err != nil {
 			fmt.Println(fmt.Errorf("car_manifest: %w", err))
 		}
 	}(mf)
 
-	entries, err := readEntries(mf)
+	entries, err := readEntries(mf, manifestFile.Path)
 	if err != nil {
 		return GraphsplitManifest{}, err
 	}
@@ -92,52 +133,240 @@ func NewGraphsplitManifest(path string) (GraphsplitManifest, error) {
 	}, nil
 }
 
-func readEntries(file *os.File) ([]GraphsplitManifestEntry, error) {
+func readEntries(r io.Reader, name string) ([]GraphsplitManifestEntry, error) {
 	var entries []GraphsplitManifestEntry
 
-	scanner := bufio.NewScanner(file)
-	buffer := tokenBufferPool.Get(tokenBufferSize)
-	defer tokenBufferPool.Put(buffer)
-	scanner.Buffer(buffer, tokenSizeMax)
-
-	if scanner.Scan() {
-		_ = scanner.Text() // skip header row
+	reader := newCSVReader(bufio.NewReaderSize(r, tokenBufferSize))
+	header, err := readHeader(reader)
+	if err != nil {
+		return nil, newEntryError(name, reader, err)
 	}
 
-	for scanner.Scan() {
-		attrs := strings.Split(scanner.Text(), ",")
-
-		payloadCID, err := cid.Parse(attrs[0])
+	for {
+		record, err := reader.Read()
 		if err != nil {
-			return nil, err
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, newEntryError(name, reader, err)
 		}
 
-		pieceCID, err := cid.Parse(attrs[2])
+		entry, err := parseGraphsplitEntry(header, record)
 		if err != nil {
-			return nil, err
+			return nil, newEntryError(name, reader, err)
 		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
 
-		payloadSize, err := strconv.ParseInt(attrs[3], 10, 64)
-		if err != nil {
-			return nil, err
+func parseGraphsplitEntry(header csvHeader, record []string) (GraphsplitManifestEntry, error) {
+	entry := GraphsplitManifestEntry{
+		FileName:   header.value(record, "filename", "file_name"),
+		PayloadCID: header.value(record, "payload_cid", "playload_cid"),
+		PieceCID:   header.value(record, "piece_cid"),
+	}
+
+	payloadCID, err := cid.Parse(entry.PayloadCID)
+	if err != nil {
+		return GraphsplitManifestEntry{}, &fieldError{field: header.index("payload_cid", "playload_cid"), err: err}
+	}
+	entry.PayloadHash = payloadCID.Hash().HexString()
+
+	pieceCID, err := cid.Parse(entry.PieceCID)
+	if err != nil {
+		return GraphsplitManifestEntry{}, &fieldError{field: header.index("piece_cid"), err: err}
+	}
+	entry.PieceHash = pieceCID.Hash().HexString()
+
+	entry.PayloadSize, err = strconv.ParseInt(header.value(record, "payload_size"), 10, 64)
+	if err != nil {
+		return GraphsplitManifestEntry{}, &fieldError{field: header.index("payload_size"), err: err}
+	}
+
+	entry.PieceSize, err = strconv.ParseInt(header.value(record, "piece_size"), 10, 64)
+	if err != nil {
+		return GraphsplitManifestEntry{}, &fieldError{field: header.index("piece_size"), err: err}
+	}
+
+	if detail := header.value(record, "detail"); detail != "" {
+		if err := json.Unmarshal([]byte(detail), &entry.Detail); err != nil {
+			return GraphsplitManifestEntry{}, &fieldError{field: header.index("detail"), err: err}
 		}
+	}
+	return entry, nil
+}
 
-		pieceSize, err := strconv.ParseInt(attrs[4], 10, 64)
-		if err != nil {
-			return nil, err
+// Join annotates the provided entries with the graphsplit rows that hold them.
+//
+// Entries are matched to the files recorded in each row's detail tree by path, falling back to the longest unambiguous
+// path suffix, which includes the file name. If the manifest has a single row without a detail tree, every entry is
+// matched to it. An entry recorded in more than one row, e.g. a file that graphsplit split across CARs, is returned
+// once for each row. The provided entries are not modified.
+func (m GraphsplitManifest) Join(entries ...*cadre.File) []GraphsplitFile {
+	index := newGraphsplitIndex(m.Entries)
+
+	var files []GraphsplitFile
+	for _, entry := range entries {
+		leaves, _ := index.lookup(entryName(entry.Path))
+		if len(leaves) == 0 && len(m.Entries) == 1 && m.Entries[0].Detail == nil {
+			leaves = []graphsplitLeaf{{row: 0}}
+		}
+
+		if len(leaves) == 0 {
+			files = append(files, GraphsplitFile{File: entry})
+			continue
+		}
+
+		for _, leaf := range leaves {
+			row := m.Entries[leaf.row]
+			f := GraphsplitFile{
+				File:       entry,
+				FileName:   row.FileName,
+				PayloadCID: row.PayloadCID,
+				PieceCID:   row.PieceCID,
+			}
+
+			if leaf.node != nil {
+				f.CID = leaf.node.Hash
+			}
+			files = append(files, f)
 		}
+	}
+	return files
+}
 
-		entries = append(entries, GraphsplitManifestEntry{
-			FileName:    attrs[1],
-			PayloadCID:  attrs[0],
-			PayloadHash: payloadCID.Hash().HexString(),
-			PayloadSize: payloadSize,
-			PieceCID:    attrs[2],
-			PieceHash:   pieceCID.Hash().HexString(),
-			PieceSize:   pieceSize,
-		})
+// Write writes the manifest to w in the format produced by graphsplit.
+func (m GraphsplitManifest) Write(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.UseCRLF = true
+	if err := writer.Write(strings.Split(GraphsplitCSVFields, ",")); err != nil {
+		return err
+	}
+
+	for _, e := range m.Entries {
+		var detail string
+		if e.Detail != nil {
+			b, err := json.Marshal(e.Detail)
+			if err != nil {
+				return err
+			}
+			detail = string(b)
+		}
+
+		record := []string{
+			e.PayloadCID,
+			e.FileName,
+			e.PieceCID,
+			strconv.FormatInt(e.PayloadSize, 10),
+			strconv.FormatInt(e.PieceSize, 10),
+			detail,
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteTo writes the manifest to manifest.csv in the directory dst, replacing any existing file atomically.
+func (m GraphsplitManifest) WriteTo(dst string) error {
+	return m.WriteToFS(DirFS(dst), ".")
+}
+
+// WriteToFS writes the manifest to manifest.csv in the directory dst in fsys.
+func (m GraphsplitManifest) WriteToFS(fsys WriteFS, dst string) error {
+	return fsys.WriteFile(path.Join(dst, GraphsplitManifestFileName), m.Write)
+}
+
+type graphsplitLeaf struct {
+	node *GraphsplitNode
+	row  int
+}
+
+// newGraphsplitIndex indexes the files in the detail trees of graphsplit rows by path and by path suffix.
+func newGraphsplitIndex(entries []GraphsplitManifestEntry) *pathIndex[graphsplitLeaf] {
+	index := newPathIndex[graphsplitLeaf]()
+	for i, e := range entries {
+		if e.Detail != nil {
+			addGraphsplitNode(index, e.Detail, "", i)
+		}
+	}
+	return index
+}
+
+func addGraphsplitNode(index *pathIndex[graphsplitLeaf], node *GraphsplitNode, parent string, row int) {
+	p := node.Name
+	if parent != "" {
+		p = parent + "/" + node.Name
+	}
+
+	if len(node.Link) == 0 {
+		if p != "" {
+			index.add(entryName(p), graphsplitLeaf{node: node, row: row})
+		}
+		return
+	}
+
+	for i := range node.Link {
+		addGraphsplitNode(index, &node.Link[i], p, row)
+	}
+}
+
+// pathIndex indexes values by slash-separated path and by path suffix, where a suffix is any trailing sequence of path
+// elements.
+type pathIndex[T any] struct {
+	paths    map[string][]T
+	suffixes map[string]string
+}
+
+func newPathIndex[T any]() *pathIndex[T] {
+	return &pathIndex[T]{
+		paths:    make(map[string][]T),
+		suffixes: make(map[string]string),
+	}
+}
+
+// add records the value for the path. A suffix shared by more than one path is marked as ambiguous.
+func (x *pathIndex[T]) add(p string, v T) {
+	if _, ok := x.paths[p]; !ok {
+		for s := p; ; {
+			if full, ok := x.suffixes[s]; ok && full != p {
+				x.suffixes[s] = ""
+			} else if !ok {
+				x.suffixes[s] = p
+			}
+
+			i := strings.Index(s, "/")
+			if i < 0 {
+				break
+			}
+			s = s[i+1:]
+		}
+	}
+	x.paths[p] = append(x.paths[p], v)
+}
+
+// lookup returns the values recorded for the path, or for the longest suffix of the path that unambiguously identifies a
+// single recorded path, along with the recorded path that matched.
+func (x *pathIndex[T]) lookup(p string) ([]T, string) {
+	for s := p; ; {
+		if values, ok := x.paths[s]; ok {
+			return values, s
+		}
+
+		if full := x.suffixes[s]; full != "" {
+			return x.paths[full], full
+		}
+
+		i := strings.Index(s, "/")
+		if i < 0 {
+			return nil, ""
+		}
+		s = s[i+1:]
 	}
-	return entries, nil
 }
 
 func (m GraphsplitManifest) String() string {
@@ -0,0 +1,328 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var ErrNoCarFiles = errors.New("no CAR files recorded for page")
+
+// CarFile is a file in the UnixFS DAG of a CAR file. Path is relative to the root of the DAG.
+type CarFile struct {
+	CID  string `json:"cid"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// CarReport describes the contents of a CAR file and any integrity problems found while reading it.
+//
+// InvalidBlocks lists the blocks whose data does not match their CID or that could not be decoded, and MissingBlocks
+// lists the blocks that are referenced by the DAG but not present in the file. PayloadCID and RootMismatch are only set
+// when the CAR is verified against a graphsplit row.
+type CarReport struct {
+	Path          string    `json:"path"`
+	Version       int       `json:"version"`
+	Roots         []string  `json:"roots"`
+	PayloadCID    string    `json:"payload_cid,omitempty"`
+	RootMismatch  bool      `json:"root_mismatch,omitempty"`
+	Blocks        int       `json:"blocks"`
+	Files         []CarFile `json:"files,omitempty"`
+	InvalidBlocks []string  `json:"invalid_blocks,omitempty"`
+	MissingBlocks []string  `json:"missing_blocks,omitempty"`
+}
+
+// InspectCar reads every block in the CAR file at path, re-hashing each block to confirm that it matches its CID, and
+// lists the files in the UnixFS DAG below the roots. Blocks are read once in file order; only the links of dag-pb nodes
+// are held in memory while the DAG is walked.
+func InspectCar(ctx context.Context, path string) (*CarReport, error) {
+	r, err := openCarFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("car_inspect: %w", err)
+	}
+	defer func(r *carFileReader) {
+		if err := r.close(); err != nil {
+			fmt.Println(fmt.Errorf("car_inspect: %w", err))
+		}
+	}(r)
+
+	report := &CarReport{Path: path, Version: r.version}
+	for _, root := range r.roots {
+		report.Roots = append(report.Roots, root.String())
+	}
+
+	nodes := make(map[string]*carNode)
+	for block, err := range r.blocks() {
+		if err != nil {
+			return nil, fmt.Errorf("car_inspect: %s: %w", path, err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Blocks++
+
+		n, err := readCarNode(block.cid, block.data)
+		if err != nil {
+			report.InvalidBlocks = append(report.InvalidBlocks, block.cid.String())
+			continue
+		}
+		nodes[block.cid.KeyString()] = n
+	}
+
+	w := &carWalker{nodes: nodes, report: report, seen: make(map[string]bool)}
+	for _, root := range r.roots {
+		w.walk(root, "")
+	}
+	return report, nil
+}
+
+// OK returns whether the CAR file was read without integrity problems and, if it was verified against a graphsplit row,
+// whether its root matches the recorded payload CID.
+func (r *CarReport) OK() bool {
+	return !r.RootMismatch && len(r.InvalidBlocks) == 0 && len(r.MissingBlocks) == 0
+}
+
+// String returns a string representation of the CarReport.
+func (r *CarReport) String() string {
+	return string(anchor.ToJSONFormatted(r))
+}
+
+// VerifyCar inspects the CAR file at path and checks that it has a single root matching the payload CID recorded for
+// the entry.
+func (e GraphsplitManifestEntry) VerifyCar(ctx context.Context, path string) (*CarReport, error) {
+	report, err := InspectCar(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	report.PayloadCID = e.PayloadCID
+	report.RootMismatch = true
+	if expected, err := cid.Parse(e.PayloadCID); err == nil && len(report.Roots) == 1 {
+		root, err := cid.Parse(report.Roots[0])
+		report.RootMismatch = err != nil || !root.Equals(expected)
+	}
+	return report, nil
+}
+
+// CarFileChange pairs an entry listed in entries.csv with the files in the page's CAR files that hold it. A file that
+// graphsplit split across CARs has one CarFile for each part.
+type CarFileChange struct {
+	Entry *cadre.File `json:"entry"`
+	Files []CarFile   `json:"files"`
+}
+
+// CarVerifyReport describes how the CAR files for a Manifest page differ from the entries listed by the page.
+type CarVerifyReport struct {
+	Namespace   string          `json:"namespace"`
+	Index       int             `json:"page"`
+	Cars        []*CarReport    `json:"cars"`
+	Verified    int             `json:"verified"`
+	Missing     []*cadre.File   `json:"missing,omitempty"`
+	SizeChanged []CarFileChange `json:"size_changed,omitempty"`
+	Unlisted    []CarFile       `json:"unlisted,omitempty"`
+}
+
+// VerifyCars verifies the CAR files for the page in the directory dir and compares the files they hold to the entries
+// listed in entries.csv by path and size.
+//
+// Each graphsplit row recorded for the page is verified with VerifyCar. If the page has no graphsplit rows, the CAR
+// named after the payload CID recorded by CarWriter is verified instead. Entries are matched to files in the CARs by
+// path, falling back to the longest unambiguous path suffix, and the sizes of the parts of a split file are summed.
+func (m *Manifest) VerifyCars(ctx context.Context, dir string) (*CarVerifyReport, error) {
+	rows := m.Graphsplit().Entries
+	if len(rows) == 0 && m.PayloadCID() != "" {
+		rows = []GraphsplitManifestEntry{{PayloadCID: m.PayloadCID()}}
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("car_verify: %w: %s", ErrNoCarFiles, m.Id())
+	}
+
+	report := &CarVerifyReport{Namespace: m.Namespace(), Index: m.Index()}
+	index := newPathIndex[CarFile]()
+	for _, row := range rows {
+		p, err := row.CarPath(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		car, err := row.VerifyCar(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		report.Cars = append(report.Cars, car)
+
+		for _, f := range car.Files {
+			index.add(entryName(f.Path), f)
+		}
+	}
+
+	matched := make(map[string]bool)
+	for entry, err := range m.Entries() {
+		if err != nil {
+			return nil, err
+		}
+
+		files, p := index.lookup(entryName(entry.Path))
+		if len(files) == 0 {
+			report.Missing = append(report.Missing, entry)
+			continue
+		}
+		matched[p] = true
+
+		var size int64
+		for _, f := range files {
+			size += f.Size
+		}
+
+		if size != entry.Size {
+			report.SizeChanged = append(report.SizeChanged, CarFileChange{Entry: entry, Files: files})
+			continue
+		}
+		report.Verified++
+	}
+
+	for _, p := range sortedPaths(index.paths) {
+		if !matched[p] {
+			report.Unlisted = append(report.Unlisted, index.paths[p]...)
+		}
+	}
+	return report, nil
+}
+
+// OK returns whether every CAR file is intact and holds exactly the files listed by the page.
+func (r *CarVerifyReport) OK() bool {
+	for _, car := range r.Cars {
+		if !car.OK() {
+			return false
+		}
+	}
+	return len(r.Missing) == 0 && len(r.SizeChanged) == 0 && len(r.Unlisted) == 0
+}
+
+// String returns a string representation of the CarVerifyReport.
+func (r *CarVerifyReport) String() string {
+	return string(anchor.ToJSONFormatted(r))
+}
+
+// carNode holds what is needed to walk a block: the links and UnixFS type of a dag-pb node, or the size of a raw block.
+type carNode struct {
+	links  []pbLink
+	raw    bool
+	typ    uint64
+	size   uint64
+	fanout uint64
+}
+
+// readCarNode re-hashes the block data to confirm that it matches the CID and decodes the parts of the block needed to
+// walk the DAG.
+func readCarNode(c cid.Cid, data []byte) (*carNode, error) {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sum.Equals(c) {
+		return nil, fmt.Errorf("block data does not match CID %s", c)
+	}
+
+	switch c.Type() {
+	case cid.Raw:
+		return &carNode{raw: true, size: uint64(len(data))}, nil
+	case cid.DagProtobuf:
+		pn, err := unmarshalPBNode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		ud, err := unmarshalUnixfsData(pn.data)
+		if err != nil {
+			return nil, err
+		}
+		return &carNode{links: pn.links, typ: ud.typ, size: ud.fileSize, fanout: ud.fanout}, nil
+	}
+	return &carNode{}, nil
+}
+
+// carWalker walks the UnixFS DAG of a CAR file, recording files and missing blocks in the report.
+type carWalker struct {
+	nodes  map[string]*carNode
+	report *CarReport
+	seen   map[string]bool
+}
+
+func (w *carWalker) walk(c cid.Cid, p string) {
+	n, ok := w.node(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case n.raw || n.typ == unixfsFile || n.typ == unixfsRaw:
+		w.report.Files = append(w.report.Files, CarFile{CID: c.String(), Path: p, Size: int64(n.size)})
+		w.walkFile(n)
+	case n.typ == unixfsDirectory:
+		for _, l := range n.links {
+			w.walk(l.hash, path.Join(p, l.name))
+		}
+	case n.typ == unixfsHAMTShard:
+		w.walkShard(n, p)
+	}
+}
+
+// walkShard walks a HAMT sharded directory. Link names are prefixed with the hex encoded bucket index, and links whose
+// name is only the prefix refer to child shards.
+func (w *carWalker) walkShard(n *carNode, p string) {
+	width := len(strconv.FormatUint(max(n.fanout, 2)-1, 16))
+	for _, l := range n.links {
+		if len(l.name) <= width {
+			if child, ok := w.node(l.hash); ok {
+				w.walkShard(child, p)
+			}
+			continue
+		}
+		w.walk(l.hash, path.Join(p, l.name[width:]))
+	}
+}
+
+// walkFile records any blocks of a file DAG that are not present in the CAR file.
+func (w *carWalker) walkFile(n *carNode) {
+	for _, l := range n.links {
+		if child, ok := w.node(l.hash); ok {
+			w.walkFile(child)
+		}
+	}
+}
+
+// node returns the node for the CID, recording the CID as missing if the block is not present in the CAR file. Blocks
+// with an identity multihash are decoded from the CID itself.
+func (w *carWalker) node(c cid.Cid) (*carNode, bool) {
+	key := c.KeyString()
+	if n, ok := w.nodes[key]; ok {
+		return n, true
+	}
+
+	if c.Prefix().MhType == multihash.IDENTITY {
+		if dmh, err := multihash.Decode(c.Hash()); err == nil {
+			if n, err := readCarNode(c, dmh.Digest); err == nil {
+				w.nodes[key] = n
+				return n, true
+			}
+		}
+	}
+
+	if !w.seen[key] {
+		w.seen[key] = true
+		w.report.MissingBlocks = append(w.report.MissingBlocks, c.String())
+	}
+	return nil, false
+}
@@ -0,0 +1,54 @@
+package car
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is the name of the file in a page directory that holds the advisory lock taken while the page is written.
+const LockFileName = ".lock"
+
+// pageLock is an advisory lock on a page directory. It excludes other processes, and other goroutines in the same
+// process, that write the same page.
+type pageLock struct {
+	file *os.File
+}
+
+// lockPage blocks until the lock on the page directory dir has been acquired.
+func lockPage(dir string) (*pageLock, error) {
+	file, err := os.OpenFile(filepath.Join(dir, LockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("locking %s: %w", file.Name(), err)
+	}
+	return &pageLock{file: file}, nil
+}
+
+// lockPageFS blocks until the lock on the page directory dir in fsys has been acquired. Only filesystems that implement
+// pageLocker, such as a DirFS, are locked; for any other filesystem a nil lock is returned.
+func lockPageFS(fsys fs.FS, dir string) (*pageLock, error) {
+	if locker, ok := fsys.(pageLocker); ok {
+		return locker.lockPage(dir)
+	}
+	return nil, nil
+}
+
+// unlock releases the lock, if any. Closing the file would release it as well, but the lock is released explicitly so
+// that an error is reported.
+func (l *pageLock) unlock() error {
+	if l == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	if cerr := l.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
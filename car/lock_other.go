@@ -0,0 +1,32 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package car
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// On platforms without advisory file locks, the lock only excludes other goroutines in the same process.
+var fileLocks sync.Map
+
+func lockFile(f *os.File) error {
+	mu, _ := fileLocks.LoadOrStore(lockKey(f), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	if mu, ok := fileLocks.Load(lockKey(f)); ok {
+		mu.(*sync.Mutex).Unlock()
+	}
+	return nil
+}
+
+func lockKey(f *os.File) string {
+	if p, err := filepath.Abs(f.Name()); err == nil {
+		return p
+	}
+	return f.Name()
+}
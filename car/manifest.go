@@ -1,22 +1,30 @@
 package car
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"iter"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/transientvariable/anchor"
 	"github.com/transientvariable/cadre"
 
+	"github.com/minio/sha256-simd"
+
 	json "github.com/json-iterator/go"
 )
 
@@ -26,20 +34,41 @@ const (
 	EntriesCSVFields = "name,path,size,sha256,mtime"
 )
 
+// ErrEntriesDigestMismatch, ErrReferencesDigestMismatch and ErrGraphsplitDigestMismatch are returned by Read when
+// entries.csv, references.csv or manifest.csv do not match the digest recorded in metadata.json.
+var (
+	ErrEntriesDigestMismatch    = errors.New("entries.csv does not match the digest recorded in metadata.json")
+	ErrReferencesDigestMismatch = errors.New("references.csv does not match the digest recorded in metadata.json")
+	ErrGraphsplitDigestMismatch = errors.New("manifest.csv does not match the digest recorded in metadata.json")
+)
+
 type Metadata struct {
-	Entries   int    `json:"entries"`
-	Index     int    `json:"page"`
-	Namespace string `json:"namespace"`
-	Size      int64  `json:"size"`
+	Columns          []string `json:"columns,omitempty"`
+	Entries          int      `json:"entries"`
+	EntriesSHA256    string   `json:"entries_sha256,omitempty"`
+	GraphsplitSHA256 string   `json:"graphsplit_sha256,omitempty"`
+	Index            int      `json:"page"`
+	Namespace        string   `json:"namespace"`
+	PayloadCID       string   `json:"payload_cid,omitempty"`
+	References       int      `json:"references,omitempty"`
+	ReferencesSHA256 string   `json:"references_sha256,omitempty"`
+	ReferencesSize   int64    `json:"references_size,omitempty"`
+	Size             int64    `json:"size"`
+	Version          int      `json:"version"`
 }
 
+// Manifest is a page of entries for a namespace. It is safe for concurrent use by multiple goroutines.
 type Manifest struct {
-	entries     []*cadre.File
-	entriesPath string
-	graphsplit  GraphsplitManifest
-	metadata    *Metadata
-	mutex       sync.RWMutex
-	path        string
+	dir            string
+	entries        []*cadre.File
+	entriesPath    string
+	fsys           fs.FS
+	graphsplit     GraphsplitManifest
+	metadata       *Metadata
+	mutex          sync.RWMutex
+	references     []Reference
+	referencesPath string
+	signingKey     ed25519.PrivateKey
 }
 
 func NewManifest(namespace string, index uint) *Manifest {
@@ -47,11 +76,15 @@ func NewManifest(namespace string, index uint) *Manifest {
 		metadata: &Metadata{
 			Namespace: namespace,
 			Index:     int(index),
+			Version:   ManifestVersion,
 		},
 	}
 }
 
 func (m *Manifest) Add(entries ...*cadre.File) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	for _, entry := range entries {
 		m.entries = append(m.entries, entry)
 		m.metadata.Size += entry.Size
@@ -60,14 +93,62 @@ func (m *Manifest) Add(entries ...*cadre.File) {
 }
 
 func (m *Manifest) Graphsplit() GraphsplitManifest {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.graphsplit
 }
 
+// GraphsplitFiles returns the page's entries annotated with the payload and piece CIDs of the graphsplit rows that
+// hold them.
+func (m *Manifest) GraphsplitFiles() ([]GraphsplitFile, error) {
+	var entries []*cadre.File
+	for entry, err := range m.Entries() {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return m.Graphsplit().Join(entries...), nil
+}
+
+// SetGraphsplit sets the graphsplit manifest for the page, which is written to manifest.csv by WriteTo.
+func (m *Manifest) SetGraphsplit(graphsplit GraphsplitManifest) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.graphsplit = graphsplit
+}
+
+// Columns returns the columns written to the page's entries.csv.
+func (m *Manifest) Columns() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return entryColumnNames(m.metadata.Columns)
+}
+
+// SetColumns sets the columns from EntriesCSVExtraFields that are written to entries.csv in addition to the columns in
+// EntriesCSVFields.
+func (m *Manifest) SetColumns(columns ...string) error {
+	normalized, err := normalizeColumns(columns)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.metadata.Columns = normalized
+	return nil
+}
+
 func (m *Manifest) Count() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.metadata.Entries
 }
 
 func (m *Manifest) EntryNames() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	var names []string
 	for _, e := range m.entries {
 		names = append(names, e.Name)
@@ -76,131 +157,244 @@ func (m *Manifest) EntryNames() []string {
 }
 
 func (m *Manifest) Id() string {
-	if m.Index() < 10 {
-		return fmt.Sprintf("0%d", m.Index())
-	}
-	return strconv.Itoa(m.Index())
+	return pageID(m.Index())
 }
 
 func (m *Manifest) Index() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.metadata.Index
 }
 
+// Metadata returns a copy of the page's metadata.
+func (m *Manifest) Metadata() Metadata {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	md := *m.metadata
+	md.Columns = slices.Clone(md.Columns)
+	return md
+}
+
 func (m *Manifest) Namespace() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.metadata.Namespace
 }
 
+// Path returns the directory the page was read from, or the directory it was last written to by WriteTo if it holds its
+// entries in memory, or the empty string if it was neither read from nor written to a filesystem. For a page read with
+// ReadFS or written with WriteToFS, the directory is relative to the root of the filesystem, unless the filesystem is a
+// DirFS.
 func (m *Manifest) Path() string {
-	return m.path
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.pagePath()
 }
 
-func (m *Manifest) ReadAllEntries() ([]*cadre.File, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	if len(m.entries) > 0 {
-		m.entries = m.entries[:]
+func (m *Manifest) pagePath() string {
+	if m.fsys == nil {
+		return ""
 	}
+	return displayPath(m.fsys, m.dir)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// PayloadCID returns the root CID of the CAR written for the page, or the empty string if none has been recorded.
+func (m *Manifest) PayloadCID() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.metadata.PayloadCID
+}
 
-	entries, err := m.ReadEntries(ctx)
-	if err != nil {
-		return nil, err
-	}
+// Entries returns an iterator over the entries in the page's entries.csv, or over the entries held by the Manifest if
+// it was not read from disk or its entries have been read with ReadAllEntries. Iteration stops after the first error,
+// which is an *EntryError when a row cannot be read or parsed.
+func (m *Manifest) Entries() iter.Seq2[*cadre.File, error] {
+	return func(yield func(*cadre.File, error) bool) {
+		m.mutex.RLock()
+		fsys, entriesPath, entries := m.fsys, m.entriesPath, slices.Clone(m.entries)
+		m.mutex.RUnlock()
+
+		if entriesPath == "" {
+			for _, entry := range entries {
+				if !yield(entry, nil) {
+					return
+				}
+			}
+			return
+		}
 
-	for entry := range entries {
-		m.Add(entry)
+		for entry, err := range readRows(fsys, entriesPath, parseEntry) {
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
 	}
-	return m.entries, nil
 }
 
-func (m *Manifest) ReadEntries(ctx context.Context) (<-chan *cadre.File, error) {
-	entries := make(chan *cadre.File)
-	go func() {
-		defer close(entries)
+// ReadAllEntries reads every entry in the page's entries.csv, returning the first error encountered, if any.
+//
+// The entries read replace any entries held by the Manifest, and the entry count and size in the page's metadata are
+// recomputed from them, so the entries can be modified with Add and written with WriteTo. From then on, Entries
+// iterates over the entries held by the Manifest rather than over entries.csv.
+func (m *Manifest) ReadAllEntries() ([]*cadre.File, error) {
+	m.mutex.RLock()
+	if m.entriesPath == "" {
+		defer m.mutex.RUnlock()
+		return slices.Clone(m.entries), nil
+	}
+	m.mutex.RUnlock()
 
-		f, err := os.Open(m.entriesPath)
+	var entries []*cadre.File
+	for entry, err := range m.Entries() {
 		if err != nil {
-			fmt.Println(fmt.Errorf("manifest: %w", err))
-			return
+			return nil, err
 		}
-		defer func(f *os.File) {
-			if err := f.Close(); err != nil {
-				fmt.Println(fmt.Errorf("manifest: %w", err))
-			}
-		}(f)
+		entries = append(entries, entry)
+	}
 
-		scanner := bufio.NewScanner(f)
-		scanner.Split(bufio.ScanLines)
-		if scanner.Scan() {
-			_ = scanner.Text() // skip header row
-		}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-		for scanner.Scan() {
-			attrs := strings.Split(scanner.Text(), ",")
+	m.entries, m.entriesPath = entries, ""
+	m.metadata.Entries = len(entries)
+	m.metadata.Size = 0
+	for _, entry := range entries {
+		m.metadata.Size += entry.Size
+	}
+	return slices.Clone(m.entries), nil
+}
 
-			size, err := strconv.ParseInt(attrs[2], 10, 64)
-			if err != nil {
-				fmt.Println(fmt.Errorf("manifest: %w", err))
-				return
-			}
+// ReadEntries streams the entries in the page's entries.csv to the returned channel. An error is returned if the first
+// entry cannot be read, e.g. because entries.csv cannot be opened. Otherwise the channel is closed once all entries
+// have been read, the context is done, or an entry cannot be read.
+//
+// Deprecated: a read error after the first entry closes the channel as if every entry had been read. Use Entries,
+// which yields read errors.
+func (m *Manifest) ReadEntries(ctx context.Context) (<-chan *cadre.File, error) {
+	next, stop := iter.Pull2(m.Entries())
+	entry, err, ok := next()
+	if err != nil {
+		stop()
+		return nil, err
+	}
 
-			mtime, err := time.Parse(time.RFC3339Nano, attrs[4])
-			if err != nil {
-				fmt.Println(fmt.Errorf("manifest: %w", err))
-				return
-			}
+	entries := make(chan *cadre.File)
+	go func() {
+		defer close(entries)
+		defer stop()
 
+		for ok && err == nil {
 			select {
-			case entries <- &cadre.File{
-				Name:  attrs[0],
-				Path:  attrs[1],
-				Size:  size,
-				Hash:  &cadre.Hash{Sha256: attrs[3]},
-				Mtime: &mtime,
-			}:
+			case entries <- entry:
 			case <-ctx.Done():
 				return
 			}
+			entry, err, ok = next()
 		}
 	}()
 	return entries, nil
 }
 
 func (m *Manifest) Size() int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.metadata.Size
 }
 
+// WriteTo writes the page to its directory under dst. Each file is written to a temporary file that is fsynced and
+// renamed into place. metadata.json is written last and records the sha256 digests of entries.csv, references.csv and
+// manifest.csv, so a page left incomplete by a crash is rejected by Read.
+//
+// An advisory lock is held on the page directory while the page is written, so that processes writing the same page
+// of a namespace are serialized.
+//
+// The page is always written in the format given by ManifestVersion. If a signing key is set with SetSigningKey, a
+// detached signature of the page is written to signature.json.
 func (m *Manifest) WriteTo(dst string) error {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	return m.WriteToFS(DirFS(dst), ".")
+}
 
-	dir := dir(dst, m.Index())
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		if err = os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
+// WriteToFS writes the page to its directory under the directory dst in fsys in the same way as WriteTo. The page
+// directory is only locked if fsys is a DirFS.
+//
+// A page read with Read or ReadFS is written with the rows of the entries.csv and references.csv it was read from,
+// unless they have been read with ReadAllEntries and ReadAllReferences, in which case the rows held by the Manifest
+// are written.
+//
+// If the page holds its entries and references in memory, e.g. a page created by NewManifest or Builder, the directory
+// is recorded as the page's Path, so that a payload CID later recorded by CarWriter is written back to it.
+func (m *Manifest) WriteToFS(fsys WriteFS, dst string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dir := path.Join(dst, pageID(m.metadata.Index))
+	if err := m.writeDir(fsys, dir); err != nil {
+		return err
 	}
 
-	file, err := os.OpenFile(filepath.Join(dir, MetadataFileName), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	if m.entriesPath == "" && m.referencesPath == "" {
+		m.fsys, m.dir = fsys, dir
+	}
+	return nil
+}
+
+func (m *Manifest) writeDir(fsys WriteFS, dir string) error {
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
+
+	lock, err := lockPageFS(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	defer func(lock *pageLock) {
+		if err := lock.unlock(); err != nil {
 			fmt.Println(fmt.Errorf("manifest: %w", err))
 		}
-	}(file)
+	}(lock)
+
+	// Rows are read from the files the page was read from before anything is written, as they may be the files that are
+	// about to be replaced.
+	entries, references := m.entries, m.references
+	if m.entriesPath != "" {
+		if entries, err = readAllRows(m.fsys, m.entriesPath, parseEntry); err != nil {
+			return err
+		}
+	}
+
+	if m.referencesPath != "" {
+		if references, err = readAllRows(m.fsys, m.referencesPath, parseReference); err != nil {
+			return err
+		}
+	}
+
+	if m.metadata.EntriesSHA256, err = m.writeEntriesTo(fsys, dir, entries); err != nil {
+		return err
+	}
 
-	if _, err = file.WriteString(m.String()); err != nil {
+	if m.metadata.ReferencesSHA256, err = m.writeReferencesTo(fsys, dir, references); err != nil {
 		return err
 	}
-	return m.writeEntriesTo(dir)
+	m.metadata.Version = ManifestVersion
+
+	if m.metadata.GraphsplitSHA256, err = m.writeGraphsplitTo(fsys, dir); err != nil {
+		return err
+	}
+
+	if err := m.writeMetadataTo(fsys, dir); err != nil {
+		return err
+	}
+	return m.writeSignatureTo(fsys, dir)
 }
 
 func (m *Manifest) String() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.string()
+}
+
+func (m *Manifest) string() string {
 	pm := make(map[string]any)
 	pm["metadata"] = m.metadata
 
@@ -223,79 +417,217 @@ func (m *Manifest) copyEntry(e *cadre.File) (*cadre.File, error) {
 	return entry, nil
 }
 
-func (m *Manifest) writeEntriesTo(dst string) error {
-	file, err := os.OpenFile(filepath.Join(dst, EntriesFileName), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+func (m *Manifest) writeMetadataTo(fsys WriteFS, dst string) error {
+	return fsys.WriteFile(path.Join(dst, MetadataFileName), func(w io.Writer) error {
+		_, err := io.WriteString(w, m.string())
 		return err
-	}
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
-			panic(err)
+	})
+}
+
+// writeGraphsplitTo writes manifest.csv to the directory dst in fsys and returns its sha256 digest. If the page has no
+// graphsplit rows, any existing manifest.csv is removed and the empty string is returned.
+func (m *Manifest) writeGraphsplitTo(fsys WriteFS, dst string) (string, error) {
+	p := path.Join(dst, GraphsplitManifestFileName)
+	if len(m.graphsplit.Entries) == 0 {
+		if err := fsys.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
 		}
-	}(file)
+		return "", nil
+	}
 
-	if _, err = file.WriteString(EntriesCSVFields + "\n"); err != nil {
-		return err
+	h := sha256.New()
+	err := fsys.WriteFile(p, func(w io.Writer) error {
+		return m.graphsplit.Write(io.MultiWriter(w, h))
+	})
+	if err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	sort.Slice(m.entries, func(i int, j int) bool { return m.entries[i].Mtime.Before(*m.entries[j].Mtime) })
+// writeEntriesTo writes the provided entries to entries.csv in the directory dst in fsys and returns its sha256 digest.
+// The entries are sorted in place.
+func (m *Manifest) writeEntriesTo(fsys WriteFS, dst string, entries []*cadre.File) (string, error) {
+	sort.SliceStable(entries, func(i int, j int) bool { return entryBefore(entries[i], entries[j]) })
 
-	for _, e := range m.entries {
-		fields := []string{
-			e.Name,
-			e.Path,
-			strconv.FormatInt(e.Size, 10),
+	h := sha256.New()
+	err := fsys.WriteFile(path.Join(dst, EntriesFileName), func(w io.Writer) error {
+		columns := entryColumnNames(m.metadata.Columns)
+		writer := csv.NewWriter(io.MultiWriter(w, h))
+		if err := writer.Write(columns); err != nil {
+			return err
 		}
 
-		if e.Hash != nil {
-			fields = append(fields, e.HashOf("sha256"))
-		} else {
-			fields = append(fields, "")
+		for _, e := range entries {
+			if err := writer.Write(formatEntry(columns, e)); err != nil {
+				return err
+			}
 		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-		if !e.Mtime.IsZero() {
-			fields = append(fields, e.Mtime.Format(time.RFC3339Nano))
-		} else {
-			fields = append(fields, "")
+// entryBefore orders entries by mtime, with entries without an mtime first, and entries with the same mtime by path, so
+// that the order of entries.csv does not depend on the order in which the entries were added.
+func entryBefore(a *cadre.File, b *cadre.File) bool {
+	switch {
+	case a.Mtime == nil && b.Mtime == nil:
+	case a.Mtime == nil || b.Mtime == nil:
+		return a.Mtime == nil
+	case !a.Mtime.Equal(*b.Mtime):
+		return a.Mtime.Before(*b.Mtime)
+	}
+	return a.Path < b.Path
+}
+
+func formatEntry(columns []string, e *cadre.File) []string {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = entryColumns[c].format(e)
+	}
+	return fields
+}
+
+// parseEntry parses the fields of the record for every known column present in the header. Empty fields leave the
+// corresponding value unset.
+func parseEntry(header csvHeader, record []string) (*cadre.File, error) {
+	entry := &cadre.File{}
+	for _, c := range entryColumnNames(strings.Split(EntriesCSVExtraFields, ",")) {
+		v := header.value(record, c)
+		if v == "" {
+			continue
 		}
 
-		if _, err = file.WriteString(strings.Join(fields, ",") + "\n"); err != nil {
-			return err
+		if err := entryColumns[c].parse(entry, v); err != nil {
+			return nil, &fieldError{field: header.index(c), err: err}
 		}
 	}
-	return nil
+	return entry, nil
 }
 
-func ReadWithIndex(src string, index int) (*Manifest, error) {
-	return Read(dir(src, index))
+func ReadWithIndex(src string, index int, trusted ...ed25519.PublicKey) (*Manifest, error) {
+	return Read(dir(src, index), trusted...)
 }
 
-func Read(src string) (*Manifest, error) {
+// Read reads the page in the directory src. If trusted public keys are provided, the page must have a signature.json
+// made by one of them that covers its current content.
+func Read(src string, trusted ...ed25519.PublicKey) (*Manifest, error) {
 	if _, err := os.Stat(src); err != nil {
 		return nil, err
 	}
+	return ReadFS(DirFS(src), ".", trusted...)
+}
 
-	graphsplit, err := NewGraphsplitManifest(filepath.Join(src, GraphsplitManifestFileName))
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
+// ReadFS reads the page in the directory dir in fsys in the same way as Read. Entries and references are read from
+// fsys as they are iterated. If fsys is a WriteFS, the page's metadata is updated through it when a CAR is written for
+// the page.
+func ReadFS(fsys fs.FS, dir string, trusted ...ed25519.PublicKey) (*Manifest, error) {
+	if len(trusted) > 0 {
+		if _, err := VerifySignatureFS(fsys, dir, trusted...); err != nil {
 			return nil, err
 		}
 	}
 
-	metadata, err := readMetadata(src)
+	metadata, err := readMetadata(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
-	return &Manifest{
-		entriesPath: filepath.Join(src, EntriesFileName),
+
+	if err := verifyDigest(fsys, dir, EntriesFileName, metadata.EntriesSHA256, ErrEntriesDigestMismatch); err != nil {
+		return nil, err
+	}
+
+	if err := verifyDigest(fsys, dir, ReferencesFileName, metadata.ReferencesSHA256, ErrReferencesDigestMismatch); err != nil {
+		return nil, err
+	}
+
+	if err := verifyDigest(fsys, dir, GraphsplitManifestFileName, metadata.GraphsplitSHA256, ErrGraphsplitDigestMismatch); err != nil {
+		return nil, err
+	}
+
+	graphsplit, err := NewGraphsplitManifestFS(fsys, path.Join(dir, GraphsplitManifestFileName))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	m := &Manifest{
+		dir:         dir,
+		entriesPath: path.Join(dir, EntriesFileName),
+		fsys:        fsys,
 		graphsplit:  graphsplit,
 		metadata:    metadata,
-		path:        src,
-	}, nil
+	}
+
+	if _, err := fs.Stat(fsys, path.Join(dir, ReferencesFileName)); err == nil {
+		m.referencesPath = path.Join(dir, ReferencesFileName)
+	}
+	return m, nil
+}
+
+// verifyDigest compares the sha256 digest of the named file in the directory dir in fsys to the expected digest. No
+// check is made if the expected digest is empty, which is the case for pages written before digests were recorded.
+func verifyDigest(fsys fs.FS, dir string, name string, expected string, mismatch error) error {
+	if expected == "" {
+		return nil
+	}
+
+	name = path.Join(dir, name)
+	digest, err := hashFile(fsys, name)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(digest, expected) {
+		return fmt.Errorf("manifest: %w: %s: computed %s, recorded %s", mismatch, displayPath(fsys, name), digest, expected)
+	}
+	return nil
 }
 
-func readMetadata(dir string) (*Metadata, error) {
-	b, err := os.ReadFile(filepath.Join(dir, MetadataFileName))
+// Upgrade rewrites the page in the directory src in the format given by ManifestVersion, returning whether the page
+// was rewritten. Pages that are already current are left untouched.
+func Upgrade(src string) (bool, error) {
+	if _, err := os.Stat(src); err != nil {
+		return false, err
+	}
+	return UpgradeFS(DirFS(src), ".")
+}
+
+// UpgradeFS rewrites the page in the directory dir in fsys in the same way as Upgrade.
+func UpgradeFS(fsys WriteFS, dir string) (bool, error) {
+	m, err := ReadFS(fsys, dir)
+	if err != nil {
+		return false, err
+	}
+
+	if m.Metadata().Version >= ManifestVersion {
+		return false, nil
+	}
+
+	if _, err := m.ReadAllEntries(); err != nil {
+		return false, err
+	}
+
+	if _, err := m.ReadAllReferences(); err != nil {
+		return false, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.writeDir(fsys, dir); err != nil {
+		return false, fmt.Errorf("manifest: %w", err)
+	}
+	return true, nil
+}
+
+func readMetadata(fsys fs.FS, dir string) (*Metadata, error) {
+	b, err := fs.ReadFile(fsys, path.Join(dir, MetadataFileName))
 	if err != nil {
 		return nil, err
 	}
@@ -315,15 +647,42 @@ func readMetadata(dir string) (*Metadata, error) {
 			return nil, err
 		}
 	}
+
+	if metadata.Version == 0 {
+		metadata.Version = 1
+	}
+
+	if metadata.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest: %w: %d", ErrUnsupportedManifestVersion, metadata.Version)
+	}
+
+	if _, err := normalizeColumns(metadata.Columns); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
 	return metadata, nil
 }
 
 func dir(path string, index int) string {
-	var dir string
-	if index < 10 {
-		dir = filepath.Join(path, fmt.Sprintf("0%d", index))
-	} else {
-		dir = filepath.Join(path, strconv.Itoa(index))
+	return filepath.Join(path, pageID(index))
+}
+
+// pageID returns the name of the directory for the page with the provided index. Indexes are zero padded to at least
+// two digits, so that directories written before the namespace grew past 99 pages keep their names; pages should be
+// ordered by parsing the name rather than by sorting names.
+func pageID(index int) string {
+	return fmt.Sprintf("%02d", index)
+}
+
+// parsePageID returns the index of the page stored in the directory with the provided name, and whether the name is a
+// page directory name.
+func parsePageID(name string) (int, bool) {
+	if name == "" || strings.TrimLeft(name, "0123456789") != "" {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, false
 	}
-	return dir
+	return index, true
 }
@@ -0,0 +1,106 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteToReadPage(t *testing.T) {
+	src := t.TempDir()
+	m := NewManifest("ns", 0)
+	m.Add(testEntry("a.txt", "aa", 1), testEntry("b.txt", "bb", 2))
+	m.AddReference(Reference{Entry: testEntry("copy.txt", "aa", 1), Index: 0, Path: "a.txt"})
+	if err := m.WriteTo(src); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(filepath.Join(src, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	if err := read.WriteTo(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := Read(filepath.Join(dst, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := written.ReadAllEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || written.Count() != 2 {
+		t.Fatalf("expected 2 entries, got %d rows and a count of %d", len(entries), written.Count())
+	}
+
+	references, err := written.ReadAllReferences()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(references) != 1 {
+		t.Fatalf("expected 1 reference, got %d", len(references))
+	}
+
+	for _, name := range []string{EntriesFileName, ReferencesFileName} {
+		expected, err := os.ReadFile(filepath.Join(src, "00", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := os.ReadFile(filepath.Join(dst, "00", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s differs from the page it was read from:\n%s\nexpected:\n%s", name, actual, expected)
+		}
+	}
+}
+
+func TestReadEntries(t *testing.T) {
+	src := t.TempDir()
+	m := NewManifest("ns", 0)
+	m.Add(testEntry("a.txt", "aa", 1), testEntry("b.txt", "bb", 2))
+	if err := m.WriteTo(src); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(filepath.Join(src, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := read.ReadEntries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	for range entries {
+		count++
+	}
+
+	if count != 2 {
+		t.Errorf("read %d entries, expected 2", count)
+	}
+
+	if err := os.Remove(filepath.Join(src, "00", EntriesFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := read.ReadEntries(context.Background()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/transientvariable/cadre"
+)
+
+const (
+	// MinPieceSize is the smallest padded piece size accepted by Filecoin.
+	MinPieceSize = 128
+
+	// DefaultOverhead is the fraction of a file's size added to its expected payload size by default to account for the
+	// DAG nodes and CAR sections of its chunks. It allows for chunks of 16 KiB or more.
+	DefaultOverhead = 0.01
+
+	// DefaultEntryOverhead is the number of bytes added to the expected payload size of each file by default to account
+	// for its root node, its CAR section and its link in the parent directory, in addition to the length of its path.
+	DefaultEntryOverhead = 192
+
+	// carOverhead is the number of bytes of each piece reserved for the CAR header and the root directory.
+	carOverhead = 1024
+)
+
+var (
+	ErrInvalidPieceSize = errors.New("piece size must be a power of two no smaller than 128 bytes")
+	ErrPieceOverflow    = errors.New("file does not fit in a single piece")
+)
+
+// PlannedPage is a Manifest page assigned by a Planner along with its expected piece sizes.
+//
+// PayloadSize is the expected payload size in bytes and PieceSize is the padded piece size in bytes, matching the units
+// recorded by GraphsplitManifestEntry.PayloadSize and GraphsplitManifestEntry.PieceSize. Padding is the number of
+// payload bytes left unused in the piece.
+type PlannedPage struct {
+	Manifest    *Manifest `json:"-"`
+	Index       int       `json:"page"`
+	Entries     int       `json:"entries"`
+	PayloadSize int64     `json:"payload_size"`
+	PieceSize   int64     `json:"piece_size"`
+	Padding     int64     `json:"padding"`
+}
+
+// Planner assigns files to Manifest pages so that the padding needed to fill each page's Filecoin piece is as small as
+// possible.
+//
+// PieceSize is the target padded piece size, e.g. 32 GiB. Overhead is the fraction of the file sizes and EntryOverhead
+// the number of bytes per file added to the expected payload size to account for the CAR and DAG encoding, so that the
+// CAR written for a page does not outgrow its piece. A zero Overhead or EntryOverhead selects DefaultOverhead or
+// DefaultEntryOverhead respectively. MaxEntries limits the number of entries per page when greater than zero.
+type Planner struct {
+	Namespace     string
+	Index         uint
+	PieceSize     int64
+	Overhead      float64
+	EntryOverhead int64
+	MaxEntries    int
+}
+
+// NewPlanner creates a new Planner for the provided namespace and target padded piece size.
+func NewPlanner(namespace string, pieceSize int64) *Planner {
+	return &Planner{
+		Namespace:     namespace,
+		PieceSize:     pieceSize,
+		Overhead:      DefaultOverhead,
+		EntryOverhead: DefaultEntryOverhead,
+	}
+}
+
+// Plan assigns the provided files to pages using best-fit decreasing bin packing against the unpadded capacity of the
+// target piece size, less 1 KiB reserved for the CAR header and the root directory.
+func (p *Planner) Plan(files ...*cadre.File) ([]PlannedPage, error) {
+	if !isPieceSize(p.PieceSize) {
+		return nil, fmt.Errorf("car_planner: %w: %d", ErrInvalidPieceSize, p.PieceSize)
+	}
+
+	capacity := UnpaddedPieceSize(p.PieceSize) - carOverhead
+	sorted := make([]*cadre.File, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i int, j int) bool { return sorted[i].Size > sorted[j].Size })
+
+	type bin struct {
+		files []*cadre.File
+		size  int64
+	}
+
+	var bins []*bin
+	for _, f := range sorted {
+		size := p.payloadSize(f)
+		if size > capacity {
+			return nil, fmt.Errorf("car_planner: %w: %s (%d bytes)", ErrPieceOverflow, f.Path, f.Size)
+		}
+
+		var best *bin
+		for _, b := range bins {
+			if p.MaxEntries > 0 && len(b.files) >= p.MaxEntries {
+				continue
+			}
+
+			if b.size+size <= capacity && (best == nil || b.size > best.size) {
+				best = b
+			}
+		}
+
+		if best == nil {
+			best = &bin{}
+			bins = append(bins, best)
+		}
+		best.files = append(best.files, f)
+		best.size += size
+	}
+
+	var pages []PlannedPage
+	for i, b := range bins {
+		m := NewManifest(p.Namespace, p.Index+uint(i))
+		m.Add(b.files...)
+
+		payloadSize := b.size + carOverhead
+		pieceSize := PaddedPieceSize(payloadSize)
+		pages = append(pages, PlannedPage{
+			Manifest:    m,
+			Index:       m.Index(),
+			Entries:     m.Count(),
+			PayloadSize: payloadSize,
+			PieceSize:   pieceSize,
+			Padding:     UnpaddedPieceSize(pieceSize) - payloadSize,
+		})
+	}
+	return pages, nil
+}
+
+// payloadSize returns the expected number of bytes added to the payload of a CAR by the file f.
+func (p *Planner) payloadSize(f *cadre.File) int64 {
+	overhead := p.Overhead
+	if overhead <= 0 {
+		overhead = DefaultOverhead
+	}
+
+	entryOverhead := p.EntryOverhead
+	if entryOverhead <= 0 {
+		entryOverhead = DefaultEntryOverhead
+	}
+	return f.Size + int64(math.Ceil(float64(f.Size)*overhead)) + entryOverhead + int64(len(f.Path))
+}
+
+// PaddedPieceSize returns the smallest power-of-two padded piece size that can hold size bytes of payload once the
+// payload has been expanded by Fr32 padding.
+func PaddedPieceSize(size int64) int64 {
+	padded := size + (size+126)/127
+	if padded <= MinPieceSize {
+		return MinPieceSize
+	}
+	return 1 << bits.Len64(uint64(padded-1))
+}
+
+// UnpaddedPieceSize returns the number of payload bytes that fit in a padded piece of the provided size, i.e. the
+// padded size less the 1/128 consumed by Fr32 padding.
+func UnpaddedPieceSize(padded int64) int64 {
+	return padded - padded/128
+}
+
+func isPieceSize(size int64) bool {
+	return size >= MinPieceSize && size&(size-1) == 0
+}
@@ -0,0 +1,91 @@
+package car
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/transientvariable/cadre"
+)
+
+func TestPlanFitsPieceSize(t *testing.T) {
+	const pieceSize = 64 * 1024
+
+	tests := []struct {
+		name   string
+		sizes  []int64
+		writer *CarWriter
+	}{
+		{
+			name:   "small files",
+			sizes:  repeatSize(600, 100),
+			writer: &CarWriter{Version: 2},
+		},
+		{
+			name:   "large files",
+			sizes:  []int64{40000, 20000, 3000, 1500, 500},
+			writer: &CarWriter{Version: 2, ChunkSize: 16 * 1024, RawLeaves: true},
+		},
+		{
+			name:   "mixed files",
+			sizes:  append(repeatSize(100, 300), 30000, 25000, 1000),
+			writer: &CarWriter{CIDVersion: 0, ChunkSize: 16 * 1024},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			var files []*cadre.File
+			for i, size := range tt.sizes {
+				p := filepath.Join("dir", fmt.Sprintf("file-%04d.bin", i))
+				b := make([]byte, size)
+				if _, err := rand.NewChaCha8([32]byte{byte(i), byte(i >> 8)}).Read(b); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filepath.Join(root, p), b, 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, &cadre.File{Name: filepath.Base(p), Path: p, Size: size})
+			}
+
+			pages, err := NewPlanner("ns", pieceSize).Plan(files...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, page := range pages {
+				dst := filepath.Join(t.TempDir(), page.Manifest.Id()+CarFileExtension)
+				if _, err := tt.writer.Write(context.Background(), page.Manifest, root, dst); err != nil {
+					t.Fatal(err)
+				}
+
+				pc, err := ComputePieceCommitmentFile(dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if pc.PieceSize > pieceSize {
+					t.Errorf("page %d: piece size %d exceeds %d (payload %d, planned %d)", page.Index, pc.PieceSize,
+						pieceSize, pc.PayloadSize, page.PayloadSize)
+				}
+			}
+		})
+	}
+}
+
+func repeatSize(n int, size int64) []int64 {
+	sizes := make([]int64, n)
+	for i := range sizes {
+		sizes[i] = size
+	}
+	return sizes
+}
@@ -0,0 +1,169 @@
+package car
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+
+	json "github.com/json-iterator/go"
+)
+
+const (
+	SignatureFileName = "signature.json"
+
+	// SignatureAlgorithm is the signature algorithm recorded in signature.json.
+	SignatureAlgorithm = "ed25519"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("page signature is invalid")
+	ErrSignatureMissing = errors.New("page is not signed")
+	ErrUntrustedSigner  = errors.New("page is signed by an untrusted key")
+)
+
+// PageSignature is a detached ed25519 signature of the files of a page, which is written to signature.json.
+//
+// Files maps the name of each signed file to its hex-encoded sha256 digest. The signature covers the lines
+// "<name> <sha256>\n" of every signed file in name order, so that large files do not need to be held in memory to be
+// signed or verified.
+type PageSignature struct {
+	Algorithm string            `json:"algorithm"`
+	PublicKey string            `json:"public_key"`
+	Files     map[string]string `json:"files"`
+	Signature string            `json:"signature"`
+}
+
+// String returns a string representation of the PageSignature.
+func (s PageSignature) String() string {
+	return string(anchor.ToJSONFormatted(s))
+}
+
+// message returns the message covered by the signature.
+func (s PageSignature) message() []byte {
+	var b bytes.Buffer
+	for _, name := range sortedPaths(s.Files) {
+		fmt.Fprintf(&b, "%s %s\n", name, s.Files[name])
+	}
+	return b.Bytes()
+}
+
+// SetSigningKey sets the key used by WriteTo to sign metadata.json, entries.csv, references.csv and manifest.csv. A
+// page written without a signing key has any existing signature.json removed.
+func (m *Manifest) SetSigningKey(key ed25519.PrivateKey) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.signingKey = key
+}
+
+// VerifySignature verifies the signature.json of the page in the directory src against the trusted public keys and
+// returns the key that signed it.
+func VerifySignature(src string, trusted ...ed25519.PublicKey) (ed25519.PublicKey, error) {
+	return VerifySignatureFS(DirFS(src), ".", trusted...)
+}
+
+// VerifySignatureFS verifies the signature of the page in the directory dir in fsys in the same way as VerifySignature.
+func VerifySignatureFS(fsys fs.FS, dir string, trusted ...ed25519.PublicKey) (ed25519.PublicKey, error) {
+	src := displayPath(fsys, dir)
+	b, err := fs.ReadFile(fsys, path.Join(dir, SignatureFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("manifest: %w: %s", ErrSignatureMissing, src)
+		}
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+
+	var sig PageSignature
+	if err := json.Unmarshal(b, &sig); err != nil {
+		return nil, fmt.Errorf("manifest: %w: %s: %w", ErrSignatureInvalid, src, err)
+	}
+
+	if sig.Algorithm != SignatureAlgorithm {
+		return nil, fmt.Errorf("manifest: %w: %s: unsupported algorithm %s", ErrSignatureInvalid, src, sig.Algorithm)
+	}
+
+	key, err := hex.DecodeString(sig.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("manifest: %w: %s: invalid public key", ErrSignatureInvalid, src)
+	}
+
+	if !slices.ContainsFunc(trusted, func(k ed25519.PublicKey) bool { return k.Equal(ed25519.PublicKey(key)) }) {
+		return nil, fmt.Errorf("manifest: %w: %s: %s", ErrUntrustedSigner, src, sig.PublicKey)
+	}
+
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(key, sig.message(), signature) {
+		return nil, fmt.Errorf("manifest: %w: %s", ErrSignatureInvalid, src)
+	}
+
+	signed := signedFiles(fsys, dir)
+	for _, name := range signed {
+		if _, ok := sig.Files[name]; !ok {
+			return nil, fmt.Errorf("manifest: %w: %s: %s is not signed", ErrSignatureInvalid, src, name)
+		}
+	}
+
+	for _, name := range sortedPaths(sig.Files) {
+		digest, err := hashFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %w: %s: %w", ErrSignatureInvalid, src, err)
+		}
+
+		if !strings.EqualFold(digest, sig.Files[name]) {
+			return nil, fmt.Errorf("manifest: %w: %s: %s has been modified", ErrSignatureInvalid, src, name)
+		}
+	}
+	return key, nil
+}
+
+// writeSignatureTo signs the page files in the directory dst in fsys with the signing key and writes signature.json, or
+// removes any existing signature.json if the Manifest has no signing key.
+func (m *Manifest) writeSignatureTo(fsys WriteFS, dst string) error {
+	p := path.Join(dst, SignatureFileName)
+	if m.signingKey == nil {
+		if err := fsys.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	sig := PageSignature{
+		Algorithm: SignatureAlgorithm,
+		PublicKey: hex.EncodeToString(m.signingKey.Public().(ed25519.PublicKey)),
+		Files:     make(map[string]string),
+	}
+
+	for _, name := range signedFiles(fsys, dst) {
+		digest, err := hashFile(fsys, path.Join(dst, name))
+		if err != nil {
+			return err
+		}
+		sig.Files[name] = digest
+	}
+	sig.Signature = hex.EncodeToString(ed25519.Sign(m.signingKey, sig.message()))
+
+	return fsys.WriteFile(p, func(w io.Writer) error {
+		_, err := io.WriteString(w, sig.String())
+		return err
+	})
+}
+
+// signedFiles returns the names of the files in the page directory dir in fsys that are covered by its signature, which
+// include manifest.csv so that the payload and piece CIDs read from it cannot be altered.
+func signedFiles(fsys fs.FS, dir string) []string {
+	names := []string{MetadataFileName, EntriesFileName}
+	for _, name := range []string{ReferencesFileName, GraphsplitManifestFileName} {
+		if _, err := fs.Stat(fsys, path.Join(dir, name)); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,129 @@
+package car
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, out string)
+		trusted ed25519.PublicKey
+		err     error
+	}{
+		{
+			name:    "signed",
+			modify:  func(t *testing.T, out string) {},
+			trusted: public,
+		},
+		{
+			name: "tampered entries.csv",
+			modify: func(t *testing.T, out string) {
+				appendFile(t, filepath.Join(out, "00", EntriesFileName), "c.txt,c.txt,3,cc,\n")
+			},
+			trusted: public,
+			err:     ErrSignatureInvalid,
+		},
+		{
+			name: "tampered manifest.csv",
+			modify: func(t *testing.T, out string) {
+				appendFile(t, filepath.Join(out, "00", GraphsplitManifestFileName), testPayloadCID+",ns-00-total-1-part-2,"+
+					testPieceCID+",1,128,\r\n")
+			},
+			trusted: public,
+			err:     ErrSignatureInvalid,
+		},
+		{
+			name:    "untrusted key",
+			modify:  func(t *testing.T, out string) {},
+			trusted: other,
+			err:     ErrUntrustedSigner,
+		},
+		{
+			name: "missing signature.json",
+			modify: func(t *testing.T, out string) {
+				if err := os.Remove(filepath.Join(out, "00", SignatureFileName)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: public,
+			err:     ErrSignatureMissing,
+		},
+		{
+			name: "unsigned rewrite",
+			modify: func(t *testing.T, out string) {
+				m, err := Read(filepath.Join(out, "00"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := m.WriteTo(out); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: public,
+			err:     ErrSignatureMissing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			m := NewManifest("ns", 0)
+			m.Add(testEntry("a.txt", "aa", 1), testEntry("b.txt", "bb", 2))
+			m.SetGraphsplit(GraphsplitManifest{Entries: []GraphsplitManifestEntry{{
+				FileName:    "ns-00-total-1-part-1",
+				PayloadCID:  testPayloadCID,
+				PayloadSize: 1000,
+				PieceCID:    testPieceCID,
+				PieceSize:   2048,
+			}}})
+			m.SetSigningKey(private)
+			if err := m.WriteTo(out); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(t, out)
+
+			key, err := VerifySignature(filepath.Join(out, "00"), tt.trusted)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+
+			if tt.err == nil && !key.Equal(public) {
+				t.Errorf("signed by %x, expected %x", key, public)
+			}
+
+			if _, err := Read(filepath.Join(out, "00"), tt.trusted); !errors.Is(err, tt.err) {
+				t.Errorf("expected Read to return %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func appendFile(t *testing.T, name string, s string) {
+	t.Helper()
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
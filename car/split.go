@@ -0,0 +1,344 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/transientvariable/cadre"
+)
+
+// PartAttribute prefixes the attributes that record the parts of an entry split across CARs by CarWriter.WriteParts.
+// Each part is recorded as "part=<file_name>:<offset>:<size>", where file_name is the graphsplit row whose CAR holds
+// the part, e.g. "part=photos-03-total-4-part-2:0:1048576" for the first MiB of the entry.
+const PartAttribute = "part="
+
+var ErrInvalidPartSize = errors.New("part size must be greater than zero")
+
+// FilePart is a part of an entry split across CARs: the graphsplit row whose CAR holds the part, and the offset and
+// size in bytes of the part within the entry.
+type FilePart struct {
+	FileName string `json:"file_name"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+}
+
+func (p FilePart) attribute() string {
+	return fmt.Sprintf("%s%s:%d:%d", PartAttribute, p.FileName, p.Offset, p.Size)
+}
+
+// PartsOf returns the parts recorded in the entry's attributes by CarWriter.WriteParts ordered by offset, or nil if the
+// entry was not split. Attributes that cannot be parsed are ignored.
+func PartsOf(f *cadre.File) []FilePart {
+	var parts []FilePart
+	for _, a := range f.Attributes {
+		v, ok := strings.CutPrefix(a, PartAttribute)
+		if !ok {
+			continue
+		}
+
+		fields := strings.Split(v, ":")
+		if len(fields) != 3 {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, FilePart{FileName: fields[0], Offset: offset, Size: size})
+	}
+
+	sort.SliceStable(parts, func(i int, j int) bool { return parts[i].Offset < parts[j].Offset })
+	return parts
+}
+
+// WriteParts packs the files listed by the Manifest, resolved against the directory tree rooted at root, into a
+// sequence of CAR files in the directory dir, each holding PartSize bytes of file content except for the last. Files
+// are added in the order of the page's entries.csv, and a file that crosses a PartSize boundary is split at it, as
+// graphsplit does for files larger than its slice size.
+//
+// Each CAR is named after its payload CID and described by a row of the returned GraphsplitManifest, whose file name
+// is "<namespace>-<page>-total-<parts>-part-<n>" and whose detail lists the files in the CAR. The location of each
+// part of a split file is recorded in the entry's attributes using PartAttribute. The graphsplit manifest is recorded
+// for the page, and the page is rewritten if the Manifest was read from disk or has been written with WriteTo. As with
+// Write, a page with a signature.json must have a signing key, otherwise ErrSigningKeyRequired is returned before any
+// CAR is written.
+func (w *CarWriter) WriteParts(ctx context.Context, m *Manifest, root string, dir string) (GraphsplitManifest, error) {
+	return w.writeParts(ctx, m, os.DirFS(root), rootEntryName(root), dir)
+}
+
+// WritePartsFS performs the same function as WriteParts using fsys as the root.
+func (w *CarWriter) WritePartsFS(ctx context.Context, m *Manifest, fsys fs.FS, dir string) (GraphsplitManifest, error) {
+	return w.writeParts(ctx, m, fsys, entryName, dir)
+}
+
+func (w *CarWriter) writeParts(ctx context.Context, m *Manifest, fsys fs.FS, resolve func(string) string, dir string) (GraphsplitManifest, error) {
+	if w.PartSize <= 0 {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w: %d", ErrInvalidPartSize, w.PartSize)
+	}
+
+	if err := m.checkWritable(); err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+
+	b, err := w.dagBuilder()
+	if err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+
+	entries, err := m.ReadAllEntries()
+	if err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+
+	if _, err := m.ReadAllReferences(); err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+	sort.SliceStable(entries, func(i int, j int) bool { return entryBefore(entries[i], entries[j]) })
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+
+	version := w.Version
+	if version == 0 {
+		version = 1
+	}
+
+	pw := &partWriter{
+		b:       b,
+		dir:     dir,
+		name:    fmt.Sprintf("%s-%s", m.Namespace(), m.Id()),
+		total:   max(1, (size+w.PartSize-1)/w.PartSize),
+		version: version,
+	}
+
+	var split bool
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return GraphsplitManifest{}, pw.abort(err)
+		}
+
+		name := resolve(entry.Path)
+		parts, err := w.fileParts(pw, fsys, name, entry.Size)
+		if err != nil {
+			return GraphsplitManifest{}, pw.abort(fmt.Errorf("car_writer: %s: %w", name, err))
+		}
+
+		entry.Attributes = slices.DeleteFunc(entry.Attributes, func(a string) bool {
+			return strings.HasPrefix(a, PartAttribute)
+		})
+
+		if len(parts) > 1 {
+			for _, part := range parts {
+				entry.Attributes = append(entry.Attributes, part.attribute())
+			}
+			split = true
+		}
+	}
+
+	if err := pw.finish(); err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+
+	graphsplit := GraphsplitManifest{Entries: pw.rows}
+	if err := m.setParts(graphsplit, split); err != nil {
+		return GraphsplitManifest{}, fmt.Errorf("car_writer: %w", err)
+	}
+	return graphsplit, nil
+}
+
+// fileParts adds the named file in fsys to the current CAR, starting a new CAR at each PartSize boundary, and returns
+// the parts the file was split into.
+func (w *CarWriter) fileParts(pw *partWriter, fsys fs.FS, name string, size int64) ([]FilePart, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f fs.File) {
+		if err := f.Close(); err != nil {
+			fmt.Println(fmt.Errorf("car_writer: %w", err))
+		}
+	}(f)
+
+	r := &countingReader{r: f}
+	var parts []FilePart
+	for offset := int64(0); offset < size || len(parts) == 0; {
+		if pw.cw == nil || (pw.used == w.PartSize && offset < size) {
+			if err := pw.next(); err != nil {
+				return nil, err
+			}
+		}
+
+		n := min(size-offset, w.PartSize-pw.used)
+		link, err := pw.b.file(io.LimitReader(r, n))
+		if err != nil {
+			return nil, err
+		}
+
+		if r.n != offset+n {
+			return nil, fmt.Errorf("%w: read %d bytes, expected %d", ErrSizeMismatch, r.n, size)
+		}
+
+		if err := pw.tree.add(name, link); err != nil {
+			return nil, err
+		}
+		parts = append(parts, FilePart{FileName: pw.fileName(), Offset: offset, Size: n})
+		offset += n
+		pw.used += n
+	}
+
+	if n, _ := io.Copy(io.Discard, io.LimitReader(r, 1)); n > 0 {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrSizeMismatch, size)
+	}
+	return parts, nil
+}
+
+// setParts records the graphsplit manifest of the CARs written for the page by WriteParts, enabling the attributes
+// column if an entry was split. The page is rewritten if it was read from or written to a filesystem, so that
+// entries.csv and manifest.csv are updated together.
+func (m *Manifest) setParts(graphsplit GraphsplitManifest, split bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.graphsplit = graphsplit
+	if split && !slices.Contains(m.metadata.Columns, "attributes") {
+		m.metadata.Columns = append(m.metadata.Columns, "attributes")
+	}
+
+	if m.fsys == nil {
+		return nil
+	}
+
+	fsys, ok := m.fsys.(WriteFS)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReadOnlyFS, m.pagePath())
+	}
+	return m.writeDir(fsys, m.dir)
+}
+
+// partWriter writes the CARs for a page split by CarWriter.WriteParts. Each CAR is written under its graphsplit file
+// name and renamed after its payload CID once it is complete.
+type partWriter struct {
+	b       *dagBuilder
+	cw      *carFileWriter
+	dir     string
+	name    string
+	rows    []GraphsplitManifestEntry
+	total   int64
+	tree    *dagDir
+	used    int64
+	version int
+}
+
+// fileName returns the graphsplit file name of the current CAR.
+func (pw *partWriter) fileName() string {
+	return fmt.Sprintf("%s-total-%d-part-%d", pw.name, pw.total, len(pw.rows)+1)
+}
+
+// next completes the current CAR, if any, and starts the next one.
+func (pw *partWriter) next() error {
+	if err := pw.finish(); err != nil {
+		return err
+	}
+
+	cw, err := newCarFileWriter(filepath.Join(pw.dir, pw.fileName()+CarFileExtension), pw.version, pw.b.placeholder())
+	if err != nil {
+		return err
+	}
+	pw.b.put = cw.put
+	pw.cw, pw.tree, pw.used = cw, newDagDir(), 0
+	return nil
+}
+
+// finish completes the current CAR, if any, and records its graphsplit row, including its piece commitment.
+func (pw *partWriter) finish() error {
+	if pw.cw == nil {
+		return nil
+	}
+
+	cw := pw.cw
+	pw.cw = nil
+
+	root, err := pw.b.directory(pw.tree)
+	if err != nil {
+		return cw.abort(err)
+	}
+
+	if err := cw.finish(root.cid); err != nil {
+		return err
+	}
+
+	detail := graphsplitDetail("", pw.tree)
+	row := GraphsplitManifestEntry{
+		FileName:    pw.fileName(),
+		PayloadCID:  root.cid.String(),
+		PayloadHash: root.cid.Hash().HexString(),
+		Detail:      &detail,
+	}
+
+	p := filepath.Join(pw.dir, row.CarFileName())
+	if err := os.Rename(cw.file.Name(), p); err != nil {
+		return err
+	}
+
+	pc, err := ComputePieceCommitmentFile(p)
+	if err != nil {
+		return err
+	}
+	row.SetPieceCommitment(pc)
+	row.PayloadSize = pc.PayloadSize
+
+	pw.rows = append(pw.rows, row)
+	return nil
+}
+
+// abort removes the partially written CAR, if any, returning the provided error. CARs that were completed are kept.
+func (pw *partWriter) abort(err error) error {
+	if pw.cw != nil {
+		return pw.cw.abort(err)
+	}
+	return err
+}
+
+// graphsplitDetail returns the detail tree recorded by graphsplit for the directory d, which must have been added to
+// the DAG. Sizes are the cumulative sizes of the linked DAGs.
+func graphsplitDetail(name string, d *dagDir) GraphsplitNode {
+	node := GraphsplitNode{Name: name, Hash: d.link.cid.String(), Size: d.link.tsize}
+
+	names := make([]string, 0, len(d.dirs)+len(d.files))
+	for n := range d.dirs {
+		names = append(names, n)
+	}
+
+	for n := range d.files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if sub, ok := d.dirs[n]; ok {
+			node.Link = append(node.Link, graphsplitDetail(n, sub))
+			continue
+		}
+
+		l := d.files[n]
+		node.Link = append(node.Link, GraphsplitNode{Name: n, Hash: l.cid.String(), Size: l.tsize})
+	}
+	return node
+}
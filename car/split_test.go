@@ -0,0 +1,140 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteParts(t *testing.T) {
+	root, _ := writeTestTree(t, testFile{path: "a.txt", size: 100}, testFile{path: "dir/big.bin", size: 10000})
+
+	output := t.TempDir()
+	if _, err := NewBuilder("ns", output).Build(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Read(filepath.Join(output, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cars := t.TempDir()
+	w := &CarWriter{ChunkSize: 1024, PartSize: 3000}
+	graphsplit, err := w.WriteParts(context.Background(), m, root, cars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(graphsplit.Entries) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(graphsplit.Entries))
+	}
+
+	for i, row := range graphsplit.Entries {
+		if expected := fmt.Sprintf("ns-00-total-4-part-%d", i+1); row.FileName != expected {
+			t.Errorf("part %d is named %s, expected %s", i+1, row.FileName, expected)
+		}
+
+		if err := row.VerifyPieceCommitment(filepath.Join(cars, row.CarFileName())); err != nil {
+			t.Error(err)
+		}
+	}
+
+	written, err := NewGraphsplitManifest(filepath.Join(output, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(written.Entries) != len(graphsplit.Entries) {
+		t.Fatalf("manifest.csv has %d rows, expected %d", len(written.Entries), len(graphsplit.Entries))
+	}
+
+	for i, row := range written.Entries {
+		if row.FileName != graphsplit.Entries[i].FileName || row.PayloadCID != graphsplit.Entries[i].PayloadCID ||
+			row.PieceCID != graphsplit.Entries[i].PieceCID || row.Detail == nil {
+			t.Errorf("manifest.csv row %d is %s, expected %s", i+1, row, graphsplit.Entries[i])
+		}
+	}
+
+	c, err := OpenCatalog(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.Lookup("dir/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("expected dir/big.bin to be recorded in 4 parts, got %d", len(entries))
+	}
+
+	parts := PartsOf(entries[0].File)
+	var offset int64
+	for i, part := range parts {
+		if part.Offset != offset || part.FileName != graphsplit.Entries[i].FileName {
+			t.Errorf("part %d is %v, expected offset %d in %s", i+1, part, offset, graphsplit.Entries[i].FileName)
+		}
+		offset += part.Size
+	}
+
+	if len(parts) != 4 || offset != 10000 {
+		t.Errorf("parts %v do not cover 10000 bytes", parts)
+	}
+
+	for _, p := range []string{"a.txt", "dir/big.bin"} {
+		entries, err := c.Lookup(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err := c.Extract(context.Background(), entries[0], cars, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b.Bytes(), expected) {
+			t.Errorf("extracted %d bytes of %s that differ from the %d bytes written", b.Len(), p, len(expected))
+		}
+	}
+}
+
+func TestWritePartsSignedPage(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, m := writeTestTree(t, testFile{path: "a.txt", size: 100})
+	output := t.TempDir()
+	m.SetSigningKey(private)
+	if err := m.WriteTo(output); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(filepath.Join(output, "00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cars := t.TempDir()
+	w := &CarWriter{PartSize: 3000}
+	if _, err := w.WriteParts(context.Background(), read, root, cars); !errors.Is(err, ErrSigningKeyRequired) {
+		t.Fatalf("expected ErrSigningKeyRequired, got %v", err)
+	}
+
+	if files, err := os.ReadDir(cars); err != nil || len(files) != 0 {
+		t.Errorf("expected no CARs to be written, got %v (%v)", files, err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package car
+
+import (
+	"io/fs"
+
+	"github.com/transientvariable/cadre"
+)
+
+// statOwner is a no-op on platforms without unix file ownership.
+func statOwner(_ *cadre.File, _ fs.FileInfo) {}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package car
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+
+	"github.com/transientvariable/cadre"
+)
+
+// statOwner sets the owner, group and inode of f from the platform specific file info, if available.
+func statOwner(f *cadre.File, info fs.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		f.UID = strconv.FormatUint(uint64(st.Uid), 10)
+		f.GID = strconv.FormatUint(uint64(st.Gid), 10)
+		f.Inode = strconv.FormatUint(uint64(st.Ino), 10)
+	}
+}
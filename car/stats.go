@@ -0,0 +1,306 @@
+package car
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+
+	json "github.com/json-iterator/go"
+)
+
+// StatsLargestFiles is the number of files listed by StatsReport.Largest.
+const StatsLargestFiles = 10
+
+// statsBuckets are the exclusive upper bounds of the size histogram buckets. Files of at least the last bound are
+// counted in a final, unbounded bucket.
+var statsBuckets = []int64{
+	1,
+	anchor.KiB,
+	64 * anchor.KiB,
+	anchor.MiB,
+	16 * anchor.MiB,
+	256 * anchor.MiB,
+	anchor.GiB,
+	16 * anchor.GiB,
+}
+
+// SizeBucket counts the entries whose size is at least Min and less than Max. Max is zero for the last bucket, which
+// has no upper bound.
+type SizeBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max,omitempty"`
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// ExtensionStats counts the entries with a file extension. Extensions are compared case-insensitively and the empty
+// extension counts entries without one.
+type ExtensionStats struct {
+	Extension string `json:"extension"`
+	Count     int    `json:"count"`
+	Size      int64  `json:"size"`
+}
+
+// PageStats summarizes a single page.
+//
+// PayloadSize and PieceSize are the totals of the page's graphsplit rows, and Efficiency is the ratio of the two, or
+// zero if the page has no piece sizes recorded.
+type PageStats struct {
+	Index       int     `json:"page"`
+	Entries     int     `json:"entries"`
+	Size        int64   `json:"size"`
+	References  int     `json:"references"`
+	Cars        int     `json:"cars"`
+	PayloadSize int64   `json:"payload_size"`
+	PieceSize   int64   `json:"piece_size"`
+	Efficiency  float64 `json:"efficiency"`
+}
+
+// StatsReport summarizes the entries of a set of pages: the distribution of their sizes and extensions, the range of
+// their mtimes, the largest files, and how efficiently the CARs recorded in the graphsplit manifests fill their padded
+// pieces.
+type StatsReport struct {
+	Namespace      string           `json:"namespace"`
+	Pages          []PageStats      `json:"pages"`
+	Entries        int              `json:"entries"`
+	Size           int64            `json:"size"`
+	References     int              `json:"references"`
+	ReferencesSize int64            `json:"references_size"`
+	OldestMtime    *time.Time       `json:"oldest_mtime,omitempty"`
+	NewestMtime    *time.Time       `json:"newest_mtime,omitempty"`
+	Histogram      []SizeBucket     `json:"histogram"`
+	Extensions     []ExtensionStats `json:"extensions"`
+	Largest        []*cadre.File    `json:"largest"`
+	Cars           int              `json:"cars"`
+	PayloadSize    int64            `json:"payload_size"`
+	PieceSize      int64            `json:"piece_size"`
+	Efficiency     float64          `json:"efficiency"`
+}
+
+// NewStatsReport creates a StatsReport for the provided pages, which are reported in the order given.
+func NewStatsReport(pages ...*Manifest) (*StatsReport, error) {
+	report := &StatsReport{}
+	for i, bound := range statsBuckets {
+		var min int64
+		if i > 0 {
+			min = statsBuckets[i-1]
+		}
+		report.Histogram = append(report.Histogram, SizeBucket{Min: min, Max: bound})
+	}
+	report.Histogram = append(report.Histogram, SizeBucket{Min: statsBuckets[len(statsBuckets)-1]})
+
+	extensions := make(map[string]*ExtensionStats)
+	for _, m := range pages {
+		if report.Namespace == "" {
+			report.Namespace = m.Namespace()
+		}
+
+		md := m.Metadata()
+		page := PageStats{Index: md.Index, References: md.References}
+		for _, e := range m.Graphsplit().Entries {
+			page.Cars++
+			page.PayloadSize += e.PayloadSize
+			page.PieceSize += e.PieceSize
+		}
+		page.Efficiency = efficiency(page.PayloadSize, page.PieceSize)
+
+		for entry, err := range m.Entries() {
+			if err != nil {
+				return nil, err
+			}
+			page.Entries++
+			page.Size += entry.Size
+			report.add(entry, extensions)
+		}
+
+		report.Entries += page.Entries
+		report.Size += page.Size
+		report.References += md.References
+		report.ReferencesSize += md.ReferencesSize
+		report.Cars += page.Cars
+		report.PayloadSize += page.PayloadSize
+		report.PieceSize += page.PieceSize
+		report.Pages = append(report.Pages, page)
+	}
+	report.Efficiency = efficiency(report.PayloadSize, report.PieceSize)
+
+	for _, e := range extensions {
+		report.Extensions = append(report.Extensions, *e)
+	}
+
+	slices.SortFunc(report.Extensions, func(a, b ExtensionStats) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.Extension, b.Extension))
+	})
+	return report, nil
+}
+
+// StatsReport creates a StatsReport for every page in the catalog.
+func (c *Catalog) StatsReport() (*StatsReport, error) {
+	return NewStatsReport(c.pages...)
+}
+
+func (r *StatsReport) add(entry *cadre.File, extensions map[string]*ExtensionStats) {
+	i, _ := slices.BinarySearch(statsBuckets, entry.Size+1)
+	r.Histogram[i].Count++
+	r.Histogram[i].Size += entry.Size
+
+	ext := entry.Extension
+	if ext == "" {
+		ext = path.Ext(entry.Name)
+	}
+	ext = strings.ToLower(ext)
+
+	if _, ok := extensions[ext]; !ok {
+		extensions[ext] = &ExtensionStats{Extension: ext}
+	}
+	extensions[ext].Count++
+	extensions[ext].Size += entry.Size
+
+	if t := entry.Mtime; t != nil && !t.IsZero() {
+		if r.OldestMtime == nil || t.Before(*r.OldestMtime) {
+			r.OldestMtime = t
+		}
+
+		if r.NewestMtime == nil || t.After(*r.NewestMtime) {
+			r.NewestMtime = t
+		}
+	}
+
+	i, _ = slices.BinarySearchFunc(r.Largest, entry, largerFile)
+	if i < StatsLargestFiles {
+		r.Largest = slices.Insert(r.Largest, i, entry)
+		if len(r.Largest) > StatsLargestFiles {
+			r.Largest = r.Largest[:StatsLargestFiles]
+		}
+	}
+}
+
+// WriteJSON writes the report to w as JSON.
+func (r *StatsReport) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteMarkdown writes the report to w as a Markdown document.
+func (r *StatsReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Manifest statistics: %s\n\n", r.Namespace)
+	fmt.Fprintf(&b, "| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Pages | %d |\n", len(r.Pages))
+	fmt.Fprintf(&b, "| Entries | %d |\n", r.Entries)
+	fmt.Fprintf(&b, "| Size | %s |\n", formatSize(r.Size))
+	fmt.Fprintf(&b, "| References | %d (%s) |\n", r.References, formatSize(r.ReferencesSize))
+	fmt.Fprintf(&b, "| Oldest mtime | %s |\n", formatTime(r.OldestMtime))
+	fmt.Fprintf(&b, "| Newest mtime | %s |\n", formatTime(r.NewestMtime))
+	fmt.Fprintf(&b, "| CARs | %d |\n", r.Cars)
+	fmt.Fprintf(&b, "| Payload size | %s |\n", formatSize(r.PayloadSize))
+	fmt.Fprintf(&b, "| Piece size | %s |\n", formatSize(r.PieceSize))
+	fmt.Fprintf(&b, "| Packing efficiency | %s |\n", formatPercent(r.Efficiency))
+
+	fmt.Fprintf(&b, "\n## Size histogram\n\n| Size | Entries | Total |\n|---|---:|---:|\n")
+	for _, bucket := range r.Histogram {
+		var label string
+		switch {
+		case bucket.Max == 1:
+			label = "0 B"
+		case bucket.Max == 0:
+			label = "≥ " + formatSize(bucket.Min)
+		default:
+			label = formatSize(max(bucket.Min, 1)) + " – " + formatSize(bucket.Max)
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s |\n", label, bucket.Count, formatSize(bucket.Size))
+	}
+
+	fmt.Fprintf(&b, "\n## Extensions\n\n| Extension | Entries | Total |\n|---|---:|---:|\n")
+	for _, e := range r.Extensions {
+		ext := e.Extension
+		if ext == "" {
+			ext = "(none)"
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s |\n", markdownEscape(ext), e.Count, formatSize(e.Size))
+	}
+
+	fmt.Fprintf(&b, "\n## Largest files\n\n| Path | Size | Mtime |\n|---|---:|---|\n")
+	for _, f := range r.Largest {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", markdownEscape(f.Path), formatSize(f.Size), formatTime(f.Mtime))
+	}
+
+	fmt.Fprintf(&b, "\n## Pages\n\n| Page | Entries | Size | References | CARs | Payload size | Piece size | Efficiency |\n")
+	fmt.Fprintf(&b, "|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, p := range r.Pages {
+		fmt.Fprintf(&b, "| %s | %d | %s | %d | %d | %s | %s | %s |\n", pageID(p.Index), p.Entries, formatSize(p.Size),
+			p.References, p.Cars, formatSize(p.PayloadSize), formatSize(p.PieceSize), formatPercent(p.Efficiency))
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// String returns a string representation of the StatsReport.
+func (r *StatsReport) String() string {
+	return string(anchor.ToJSONFormatted(r))
+}
+
+// largerFile orders files by descending size, then by path.
+func largerFile(a *cadre.File, b *cadre.File) int {
+	return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.Path, b.Path))
+}
+
+func efficiency(payloadSize int64, pieceSize int64) float64 {
+	if pieceSize <= 0 {
+		return 0
+	}
+	return float64(payloadSize) / float64(pieceSize)
+}
+
+// formatSize formats a size in bytes using IEC units.
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	v, i := float64(size), 0
+	for v >= anchor.KiB && i < len(units)-1 {
+		v /= anchor.KiB
+		i++
+	}
+
+	if i == 0 {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	s := strings.TrimRight(strings.TrimRight(strconv.FormatFloat(v, 'f', 2, 64), "0"), ".")
+	return s + " " + units[i]
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatPercent(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(v*100, 'f', 2, 64) + "%"
+}
+
+// markdownEscape escapes the characters that would break a Markdown table cell.
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
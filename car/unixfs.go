@@ -0,0 +1,558 @@
+package car
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/minio/sha256-simd"
+	"github.com/multiformats/go-multihash"
+)
+
+// UnixFS data types, as defined by the UnixFS specification.
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsMetadata  = 3
+	unixfsSymlink   = 4
+	unixfsHAMTShard = 5
+)
+
+// dag-pb and UnixFS protobuf field numbers and wire types.
+const (
+	pbWireVarint  = 0
+	pbWireFixed64 = 1
+	pbWireBytes   = 2
+	pbWireFixed32 = 5
+
+	pbNodeData  = 1
+	pbNodeLinks = 2
+
+	pbLinkHash  = 1
+	pbLinkName  = 2
+	pbLinkTsize = 3
+
+	unixfsFieldType       = 1
+	unixfsFieldData       = 2
+	unixfsFieldFileSize   = 3
+	unixfsFieldBlockSizes = 4
+	unixfsFieldFanout     = 6
+
+	// depthRepeat is the number of sub-trees of each depth added to a node by the trickle layout.
+	depthRepeat = 4
+)
+
+var (
+	ErrInvalidDagLayout = errors.New("unknown DAG layout")
+	ErrInvalidNode      = errors.New("invalid dag-pb or UnixFS node")
+)
+
+// DagLayout identifies the strategy used to arrange the chunks of a file into a UnixFS DAG.
+type DagLayout string
+
+// Enumeration of DAG layouts.
+const (
+	LayoutBalanced DagLayout = "balanced"
+	LayoutTrickle  DagLayout = "trickle"
+)
+
+type pbLink struct {
+	hash  cid.Cid
+	name  string
+	tsize uint64
+}
+
+// pbNode is a dag-pb node. A nil data field is omitted from the encoded node.
+type pbNode struct {
+	links []pbLink
+	data  []byte
+}
+
+// marshal encodes the node in canonical dag-pb form, with links preceding data.
+func (n pbNode) marshal() []byte {
+	var b []byte
+	for _, l := range n.links {
+		var lb []byte
+		lb = appendBytesField(lb, pbLinkHash, l.hash.Bytes())
+		lb = appendBytesField(lb, pbLinkName, []byte(l.name))
+		lb = appendVarintField(lb, pbLinkTsize, l.tsize)
+		b = appendBytesField(b, pbNodeLinks, lb)
+	}
+
+	if n.data != nil {
+		b = appendBytesField(b, pbNodeData, n.data)
+	}
+	return b
+}
+
+// unmarshalPBNode decodes a dag-pb node. The returned data field refers to b.
+func unmarshalPBNode(b []byte) (pbNode, error) {
+	var n pbNode
+	err := readPBFields(b, func(field int, wire int, _ uint64, v []byte) error {
+		switch {
+		case field == pbNodeData && wire == pbWireBytes:
+			n.data = v
+		case field == pbNodeLinks && wire == pbWireBytes:
+			l, err := unmarshalPBLink(v)
+			if err != nil {
+				return err
+			}
+			n.links = append(n.links, l)
+		default:
+			return fmt.Errorf("%w: unexpected field %d in node", ErrInvalidNode, field)
+		}
+		return nil
+	})
+	return n, err
+}
+
+func unmarshalPBLink(b []byte) (pbLink, error) {
+	var l pbLink
+	err := readPBFields(b, func(field int, wire int, u uint64, v []byte) error {
+		switch {
+		case field == pbLinkHash && wire == pbWireBytes:
+			c, err := cid.Cast(v)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidNode, err)
+			}
+			l.hash = c
+		case field == pbLinkName && wire == pbWireBytes:
+			l.name = string(v)
+		case field == pbLinkTsize && wire == pbWireVarint:
+			l.tsize = u
+		default:
+			return fmt.Errorf("%w: unexpected field %d in link", ErrInvalidNode, field)
+		}
+		return nil
+	})
+
+	if err == nil && !l.hash.Defined() {
+		err = fmt.Errorf("%w: link without hash", ErrInvalidNode)
+	}
+	return l, err
+}
+
+// readPBFields calls fn for each field of the protobuf message in b. Varint fields are passed as u and length-delimited
+// fields as v; fixed-width fields are skipped.
+func readPBFields(b []byte, fn func(field int, wire int, u uint64, v []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: malformed field key", ErrInvalidNode)
+		}
+		b = b[n:]
+
+		field, wire := int(key>>3), int(key&7)
+		switch wire {
+		case pbWireVarint:
+			u, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("%w: malformed varint in field %d", ErrInvalidNode, field)
+			}
+			b = b[n:]
+
+			if err := fn(field, wire, u, nil); err != nil {
+				return err
+			}
+		case pbWireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return fmt.Errorf("%w: malformed length in field %d", ErrInvalidNode, field)
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+
+			if err := fn(field, wire, 0, v); err != nil {
+				return err
+			}
+		case pbWireFixed64, pbWireFixed32:
+			size := 8
+			if wire == pbWireFixed32 {
+				size = 4
+			}
+
+			if len(b) < size {
+				return fmt.Errorf("%w: truncated field %d", ErrInvalidNode, field)
+			}
+			b = b[size:]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d in field %d", ErrInvalidNode, wire, field)
+		}
+	}
+	return nil
+}
+
+// unixfsData is the UnixFS protobuf message held in the data field of a dag-pb node. The file size is omitted for
+// directories.
+type unixfsData struct {
+	typ        uint64
+	data       []byte
+	fileSize   uint64
+	blockSizes []uint64
+	fanout     uint64
+}
+
+func (d unixfsData) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, unixfsFieldType, d.typ)
+	if d.data != nil {
+		b = appendBytesField(b, unixfsFieldData, d.data)
+	}
+
+	if d.typ != unixfsDirectory {
+		b = appendVarintField(b, unixfsFieldFileSize, d.fileSize)
+	}
+
+	for _, s := range d.blockSizes {
+		b = appendVarintField(b, unixfsFieldBlockSizes, s)
+	}
+	return b
+}
+
+// unmarshalUnixfsData decodes a UnixFS data message. The returned data field refers to b.
+func unmarshalUnixfsData(b []byte) (unixfsData, error) {
+	var d unixfsData
+	typed := false
+	err := readPBFields(b, func(field int, wire int, u uint64, v []byte) error {
+		switch {
+		case field == unixfsFieldType && wire == pbWireVarint:
+			d.typ, typed = u, true
+		case field == unixfsFieldData && wire == pbWireBytes:
+			d.data = v
+		case field == unixfsFieldFileSize && wire == pbWireVarint:
+			d.fileSize = u
+		case field == unixfsFieldBlockSizes && wire == pbWireVarint:
+			d.blockSizes = append(d.blockSizes, u)
+		case field == unixfsFieldFanout && wire == pbWireVarint:
+			d.fanout = u
+		}
+		return nil
+	})
+
+	if err == nil && !typed {
+		err = fmt.Errorf("%w: UnixFS data without type", ErrInvalidNode)
+	}
+	return d, err
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|pbWireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|pbWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// dagLink references a node added to the DAG. Tsize is the cumulative size of the encoded node and every node below it
+// and size is the number of file bytes it represents.
+type dagLink struct {
+	cid   cid.Cid
+	tsize uint64
+	size  uint64
+}
+
+// dagBuilder builds UnixFS DAGs, passing each encoded block to put as soon as it is complete.
+type dagBuilder struct {
+	chunkSize  int
+	cidVersion int
+	layout     DagLayout
+	maxLinks   int
+	rawLeaves  bool
+	put        func(c cid.Cid, data []byte) error
+}
+
+// file chunks the content read from r and arranges the chunks into a DAG using the builder's layout.
+func (b *dagBuilder) file(r io.Reader) (dagLink, error) {
+	c := newChunker(r, b.chunkSize)
+	switch b.layout {
+	case LayoutBalanced, "":
+		return b.balanced(c)
+	case LayoutTrickle:
+		return b.fillTrickle(c, &fileNode{}, -1)
+	}
+	return dagLink{}, fmt.Errorf("%w: %s", ErrInvalidDagLayout, b.layout)
+}
+
+// balanced arranges chunks so that every leaf is at the same depth, growing the tree by one level each time the root
+// is full.
+func (b *dagBuilder) balanced(c *chunker) (dagLink, error) {
+	if c.done() {
+		return b.leaf(nil, unixfsFile)
+	}
+
+	root, err := b.leafData(c, unixfsFile)
+	if err != nil {
+		return dagLink{}, err
+	}
+
+	for depth := 1; !c.done(); depth++ {
+		n := &fileNode{}
+		n.add(root)
+		if root, err = b.fillBalanced(c, n, depth); err != nil {
+			return dagLink{}, err
+		}
+	}
+	return root, nil
+}
+
+func (b *dagBuilder) fillBalanced(c *chunker, n *fileNode, depth int) (dagLink, error) {
+	for len(n.links) < b.maxLinks && !c.done() {
+		var child dagLink
+		var err error
+		if depth == 1 {
+			child, err = b.leafData(c, unixfsFile)
+		} else {
+			child, err = b.fillBalanced(c, &fileNode{}, depth-1)
+		}
+
+		if err != nil {
+			return dagLink{}, err
+		}
+		n.add(child)
+	}
+	return b.commit(n)
+}
+
+// fillTrickle fills a layer of leaves followed by depthRepeat sub-trees of each increasing depth below maxDepth, or
+// without limit if maxDepth is -1.
+func (b *dagBuilder) fillTrickle(c *chunker, n *fileNode, maxDepth int) (dagLink, error) {
+	for len(n.links) < b.maxLinks && !c.done() {
+		child, err := b.leafData(c, unixfsRaw)
+		if err != nil {
+			return dagLink{}, err
+		}
+		n.add(child)
+	}
+
+	for depth := 1; maxDepth == -1 || depth < maxDepth; depth++ {
+		if c.done() {
+			break
+		}
+
+		for i := 0; i < depthRepeat && !c.done(); i++ {
+			child, err := b.fillTrickle(c, &fileNode{}, depth)
+			if err != nil {
+				return dagLink{}, err
+			}
+			n.add(child)
+		}
+	}
+	return b.commit(n)
+}
+
+func (b *dagBuilder) leafData(c *chunker, typ uint64) (dagLink, error) {
+	data, err := c.next()
+	if err != nil {
+		return dagLink{}, err
+	}
+	return b.leaf(data, typ)
+}
+
+func (b *dagBuilder) leaf(data []byte, typ uint64) (dagLink, error) {
+	if b.rawLeaves {
+		if data == nil {
+			data = []byte{}
+		}
+
+		c, err := b.sum(cid.Raw, data)
+		if err != nil {
+			return dagLink{}, err
+		}
+		return dagLink{cid: c, tsize: uint64(len(data)), size: uint64(len(data))}, b.put(c, data)
+	}
+
+	node := pbNode{data: unixfsData{typ: typ, data: data, fileSize: uint64(len(data))}.marshal()}
+	return b.node(node, uint64(len(data)))
+}
+
+func (b *dagBuilder) commit(n *fileNode) (dagLink, error) {
+	node := pbNode{
+		links: n.links,
+		data:  unixfsData{typ: unixfsFile, fileSize: n.size, blockSizes: n.blockSizes}.marshal(),
+	}
+	return b.node(node, n.size)
+}
+
+// directory adds the directory d and all of its descendants to the DAG.
+func (b *dagBuilder) directory(d *dagDir) (dagLink, error) {
+	names := make([]string, 0, len(d.dirs)+len(d.files))
+	for name := range d.dirs {
+		names = append(names, name)
+	}
+
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	links := make([]pbLink, 0, len(names))
+	for _, name := range names {
+		l, ok := d.files[name]
+		if !ok {
+			var err error
+			if l, err = b.directory(d.dirs[name]); err != nil {
+				return dagLink{}, err
+			}
+		}
+		links = append(links, pbLink{hash: l.cid, name: name, tsize: l.tsize})
+	}
+
+	l, err := b.node(pbNode{links: links, data: unixfsData{typ: unixfsDirectory}.marshal()}, 0)
+	if err != nil {
+		return dagLink{}, err
+	}
+	d.link = l
+	return l, nil
+}
+
+func (b *dagBuilder) node(n pbNode, size uint64) (dagLink, error) {
+	data := n.marshal()
+	c, err := b.sum(cid.DagProtobuf, data)
+	if err != nil {
+		return dagLink{}, err
+	}
+
+	tsize := uint64(len(data))
+	for _, l := range n.links {
+		tsize += l.tsize
+	}
+	return dagLink{cid: c, tsize: tsize, size: size}, b.put(c, data)
+}
+
+func (b *dagBuilder) sum(codec uint64, data []byte) (cid.Cid, error) {
+	digest := sha256.Sum256(data)
+	mh, err := multihash.Encode(digest[:], multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if b.cidVersion == 0 && codec == cid.DagProtobuf {
+		return cid.NewCidV0(mh), nil
+	}
+	return cid.NewCidV1(codec, mh), nil
+}
+
+// placeholder returns a CID with the same encoded length as the CID of a directory node built by b.
+func (b *dagBuilder) placeholder() cid.Cid {
+	c, _ := b.sum(cid.DagProtobuf, nil)
+	return c
+}
+
+// fileNode accumulates the children of an internal file node.
+type fileNode struct {
+	links      []pbLink
+	blockSizes []uint64
+	size       uint64
+}
+
+func (n *fileNode) add(l dagLink) {
+	n.links = append(n.links, pbLink{hash: l.cid, tsize: l.tsize})
+	n.blockSizes = append(n.blockSizes, l.size)
+	n.size += l.size
+}
+
+// dagDir is a directory in the tree of files added to a DAG. link is set once the directory has been added to the DAG.
+type dagDir struct {
+	dirs  map[string]*dagDir
+	files map[string]dagLink
+	link  dagLink
+}
+
+func newDagDir() *dagDir {
+	return &dagDir{
+		dirs:  make(map[string]*dagDir),
+		files: make(map[string]dagLink),
+	}
+}
+
+// add adds the file link at the slash-separated path p, creating intermediate directories as needed.
+func (d *dagDir) add(p string, l dagLink) error {
+	dir, name := d, p
+	for {
+		i := strings.IndexByte(name, '/')
+		if i < 0 {
+			break
+		}
+
+		sub, ok := dir.dirs[name[:i]]
+		if !ok {
+			if _, ok := dir.files[name[:i]]; ok {
+				return fmt.Errorf("path conflicts with a file: %s", p)
+			}
+			sub = newDagDir()
+			dir.dirs[name[:i]] = sub
+		}
+		dir, name = sub, name[i+1:]
+	}
+
+	if _, ok := dir.dirs[name]; ok {
+		return fmt.Errorf("path conflicts with a directory: %s", p)
+	}
+
+	if _, ok := dir.files[name]; ok {
+		return fmt.Errorf("duplicate path: %s", p)
+	}
+	dir.files[name] = l
+	return nil
+}
+
+// chunker splits a reader into fixed-size chunks with one chunk of lookahead, so that done reports whether another
+// chunk is available before it is consumed.
+type chunker struct {
+	r       io.Reader
+	buffers [2][]byte
+	current int
+	pending []byte
+	err     error
+	primed  bool
+}
+
+func newChunker(r io.Reader, size int) *chunker {
+	return &chunker{
+		r:       r,
+		buffers: [2][]byte{make([]byte, size), make([]byte, size)},
+	}
+}
+
+func (c *chunker) prepare() {
+	if c.primed {
+		return
+	}
+	c.primed = true
+
+	c.current = 1 - c.current
+	n, err := io.ReadFull(c.r, c.buffers[c.current])
+	if n > 0 {
+		c.pending = c.buffers[c.current][:n]
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		c.err = err
+	}
+}
+
+func (c *chunker) done() bool {
+	c.prepare()
+	return c.err == nil && c.pending == nil
+}
+
+// next returns the next chunk. The returned slice is only valid until the chunk after it has been prepared.
+func (c *chunker) next() ([]byte, error) {
+	c.prepare()
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	data := c.pending
+	c.pending = nil
+	c.primed = false
+	return data, nil
+}
@@ -0,0 +1,217 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/transientvariable/anchor"
+	"github.com/transientvariable/cadre"
+	"github.com/transientvariable/cadre/ecs"
+	"github.com/transientvariable/cadre/storage"
+)
+
+// EntryChange pairs an entry listed in entries.csv with the file currently on disk.
+type EntryChange struct {
+	Entry *cadre.File `json:"entry"`
+	File  *cadre.File `json:"file"`
+}
+
+// VerifyReport describes how the files on disk differ from the entries listed by one or more Manifest pages.
+type VerifyReport struct {
+	Namespace    string        `json:"namespace"`
+	Verified     int           `json:"verified"`
+	Missing      []*cadre.File `json:"missing,omitempty"`
+	SizeChanged  []EntryChange `json:"size_changed,omitempty"`
+	HashChanged  []EntryChange `json:"hash_changed,omitempty"`
+	MtimeChanged []EntryChange `json:"mtime_changed,omitempty"`
+	Unlisted     []*cadre.File `json:"unlisted,omitempty"`
+}
+
+// Verify re-stats and re-hashes every entry and reference listed by the provided manifests against the directory tree
+// rooted at root. Regular files under root that are not listed by any of the manifests are reported as unlisted.
+func Verify(ctx context.Context, root string, manifests ...*Manifest) (*VerifyReport, error) {
+	return verify(ctx, os.DirFS(root), rootEntryName(root), manifests...)
+}
+
+// VerifyFS performs the same function as Verify using fsys as the root.
+func VerifyFS(ctx context.Context, fsys fs.FS, manifests ...*Manifest) (*VerifyReport, error) {
+	return verify(ctx, fsys, entryName, manifests...)
+}
+
+func verify(ctx context.Context, fsys fs.FS, resolve func(string) string, manifests ...*Manifest) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	listed := make(map[string]bool)
+	for _, m := range manifests {
+		if report.Namespace == "" {
+			report.Namespace = m.Namespace()
+		}
+
+		for entry, err := range listedEntries(m) {
+			if err != nil {
+				return nil, err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			name := resolve(entry.Path)
+			listed[name] = true
+
+			f, err := statFile(fsys, name)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					report.Missing = append(report.Missing, entry)
+					continue
+				}
+				return nil, fmt.Errorf("car_verify: %w", err)
+			}
+			report.compare(entry, f)
+		}
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() || listed[name] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mtime := info.ModTime()
+		report.Unlisted = append(report.Unlisted, &cadre.File{
+			Directory: path.Dir(name),
+			Extension: path.Ext(name),
+			Mtime:     &mtime,
+			Name:      info.Name(),
+			Path:      name,
+			Size:      info.Size(),
+			Type:      "file",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("car_verify: %w", err)
+	}
+	return report, nil
+}
+
+// Events returns an iterator over storage events describing the report: a deletion event for each missing entry and a
+// change event for each entry whose size, sha256 or mtime no longer matches the file on disk.
+func (r *VerifyReport) Events() iter.Seq2[*storage.Event, error] {
+	return func(yield func(*storage.Event, error) bool) {
+		for _, entry := range r.Missing {
+			if !yield(storage.NewStorageEvent(ecs.EventTypeDeletion, r.Namespace, entry)) {
+				return
+			}
+		}
+
+		changed := make(map[string]bool)
+		for _, changes := range [][]EntryChange{r.SizeChanged, r.HashChanged, r.MtimeChanged} {
+			for _, c := range changes {
+				if changed[c.File.Path] {
+					continue
+				}
+				changed[c.File.Path] = true
+
+				if !yield(storage.NewStorageEvent(ecs.EventTypeChange, r.Namespace, c.File)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// OK returns whether the files on disk match the listed entries exactly.
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing) == 0 &&
+		len(r.SizeChanged) == 0 &&
+		len(r.HashChanged) == 0 &&
+		len(r.MtimeChanged) == 0 &&
+		len(r.Unlisted) == 0
+}
+
+// String returns a string representation of the VerifyReport.
+func (r *VerifyReport) String() string {
+	return string(anchor.ToJSONFormatted(r))
+}
+
+func (r *VerifyReport) compare(entry *cadre.File, f *cadre.File) {
+	change := EntryChange{Entry: entry, File: f}
+	matched := true
+	if entry.Size != f.Size {
+		r.SizeChanged = append(r.SizeChanged, change)
+		matched = false
+	}
+
+	if sha256 := entry.HashOf("sha256"); sha256 != "" && !strings.EqualFold(sha256, f.HashOf("sha256")) {
+		r.HashChanged = append(r.HashChanged, change)
+		matched = false
+	}
+
+	if entry.Mtime != nil && !entry.Mtime.Equal(*f.Mtime) {
+		r.MtimeChanged = append(r.MtimeChanged, change)
+		matched = false
+	}
+
+	if matched {
+		r.Verified++
+	}
+}
+
+// listedEntries returns an iterator over the entries of the page followed by the entries recorded as references.
+func listedEntries(m *Manifest) iter.Seq2[*cadre.File, error] {
+	return func(yield func(*cadre.File, error) bool) {
+		for entry, err := range m.Entries() {
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+
+		for r, err := range m.References() {
+			if !yield(r.Entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// rootEntryName returns a function that converts an entry path to a name relative to root. Absolute entry paths are
+// made relative to root and all other paths are assumed to be relative to root already.
+func rootEntryName(root string) func(string) string {
+	return func(p string) string {
+		if filepath.IsAbs(p) {
+			if rel, err := filepath.Rel(root, p); err == nil {
+				p = rel
+			}
+		}
+		return entryName(p)
+	}
+}
+
+// entryName converts an entry path to a name that can be opened with an fs.FS.
+func entryName(p string) string {
+	p = path.Clean(filepath.ToSlash(p))
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
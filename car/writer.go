@@ -0,0 +1,254 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/transientvariable/anchor"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	DefaultChunkSize = anchor.MiB
+	DefaultMaxLinks  = 1024
+)
+
+var (
+	ErrSigningKeyRequired = errors.New("page is signed and no signing key is set")
+	ErrSizeMismatch       = errors.New("file size does not match the size recorded for the entry")
+)
+
+// CarWriter packs the files listed by a Manifest page into a CAR file as a UnixFS DAG rooted at a directory that mirrors
+// the entry paths.
+//
+// Version selects CARv1 or CARv2, where CARv2 adds a car-multihash-index-sorted index. CIDVersion selects CIDv0 or
+// CIDv1 for dag-pb nodes; raw leaves always use CIDv1. A zero ChunkSize, MaxLinks, Layout or Version selects
+// DefaultChunkSize, DefaultMaxLinks, LayoutBalanced and CARv1 respectively.
+//
+// PartSize is the number of bytes of file content held by each CAR written by WriteParts, at which boundaries files
+// are split across CARs. It is ignored by Write.
+type CarWriter struct {
+	Version    int
+	CIDVersion int
+	ChunkSize  int
+	MaxLinks   int
+	Layout     DagLayout
+	RawLeaves  bool
+	PartSize   int64
+}
+
+// NewCarWriter creates a new CarWriter that produces CARv1 files using CIDv1, 1 MiB chunks and balanced DAGs with up
+// to 1024 links per node, which matches the output of graphsplit.
+func NewCarWriter() *CarWriter {
+	return &CarWriter{
+		Version:    1,
+		CIDVersion: 1,
+		ChunkSize:  DefaultChunkSize,
+		MaxLinks:   DefaultMaxLinks,
+		Layout:     LayoutBalanced,
+	}
+}
+
+// Write packs the files listed by the Manifest, resolved against the directory tree rooted at root, into the CAR file
+// at dst and returns the payload root CID. The payload CID is recorded in the page's metadata, and metadata.json is
+// rewritten if the Manifest was read from disk or has been written with WriteTo, such as a page that a Builder wrote
+// to its Output.
+//
+// A page with a signature.json must have a signing key set with SetSigningKey, so that it can be signed again once
+// metadata.json is rewritten. Otherwise ErrSigningKeyRequired is returned before the CAR is written.
+func (w *CarWriter) Write(ctx context.Context, m *Manifest, root string, dst string) (cid.Cid, error) {
+	return w.write(ctx, m, os.DirFS(root), rootEntryName(root), dst)
+}
+
+// WriteFS performs the same function as Write using fsys as the root.
+func (w *CarWriter) WriteFS(ctx context.Context, m *Manifest, fsys fs.FS, dst string) (cid.Cid, error) {
+	return w.write(ctx, m, fsys, entryName, dst)
+}
+
+func (w *CarWriter) write(ctx context.Context, m *Manifest, fsys fs.FS, resolve func(string) string, dst string) (cid.Cid, error) {
+	if err := m.checkWritable(); err != nil {
+		return cid.Undef, fmt.Errorf("car_writer: %w", err)
+	}
+
+	b, err := w.dagBuilder()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("car_writer: %w", err)
+	}
+
+	version := w.Version
+	if version == 0 {
+		version = 1
+	}
+
+	cw, err := newCarFileWriter(dst, version, b.placeholder())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("car_writer: %w", err)
+	}
+	b.put = cw.put
+
+	tree := newDagDir()
+	for entry, err := range m.Entries() {
+		if err != nil {
+			return cid.Undef, cw.abort(err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return cid.Undef, cw.abort(err)
+		}
+
+		name := resolve(entry.Path)
+		link, err := w.file(b, fsys, name, entry.Size)
+		if err != nil {
+			return cid.Undef, cw.abort(fmt.Errorf("car_writer: %s: %w", name, err))
+		}
+
+		if err := tree.add(name, link); err != nil {
+			return cid.Undef, cw.abort(fmt.Errorf("car_writer: %w", err))
+		}
+	}
+
+	root, err := b.directory(tree)
+	if err != nil {
+		return cid.Undef, cw.abort(fmt.Errorf("car_writer: %w", err))
+	}
+
+	if err := cw.finish(root.cid); err != nil {
+		return cid.Undef, fmt.Errorf("car_writer: %w", err)
+	}
+
+	if err := m.setPayloadCID(root.cid); err != nil {
+		return cid.Undef, fmt.Errorf("car_writer: %w", err)
+	}
+	return root.cid, nil
+}
+
+func (w *CarWriter) file(b *dagBuilder, fsys fs.FS, name string, size int64) (dagLink, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return dagLink{}, err
+	}
+	defer func(f fs.File) {
+		if err := f.Close(); err != nil {
+			fmt.Println(fmt.Errorf("car_writer: %w", err))
+		}
+	}(f)
+
+	r := &countingReader{r: f}
+	link, err := b.file(r)
+	if err != nil {
+		return dagLink{}, err
+	}
+
+	if r.n != size {
+		return dagLink{}, fmt.Errorf("%w: read %d bytes, expected %d", ErrSizeMismatch, r.n, size)
+	}
+	return link, nil
+}
+
+func (w *CarWriter) dagBuilder() (*dagBuilder, error) {
+	b := &dagBuilder{
+		chunkSize:  w.ChunkSize,
+		cidVersion: w.CIDVersion,
+		layout:     w.Layout,
+		maxLinks:   w.MaxLinks,
+		rawLeaves:  w.RawLeaves,
+	}
+
+	if b.chunkSize <= 0 {
+		b.chunkSize = DefaultChunkSize
+	}
+
+	if b.maxLinks <= 0 {
+		b.maxLinks = DefaultMaxLinks
+	}
+
+	if b.layout == "" {
+		b.layout = LayoutBalanced
+	}
+
+	if b.layout != LayoutBalanced && b.layout != LayoutTrickle {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDagLayout, b.layout)
+	}
+
+	if b.cidVersion != 0 && b.cidVersion != 1 {
+		return nil, fmt.Errorf("unsupported CID version: %d", b.cidVersion)
+	}
+	return b, nil
+}
+
+// checkWritable returns an error if the page was read from or written to a filesystem and cannot be rewritten once a
+// CAR has been written for it, either because the filesystem is read-only or because the page is signed and the
+// Manifest has no signing key.
+func (m *Manifest) checkWritable() error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.fsys == nil {
+		return nil
+	}
+
+	if _, ok := m.fsys.(WriteFS); !ok {
+		return fmt.Errorf("%w: %s", ErrReadOnlyFS, m.pagePath())
+	}
+
+	if m.signingKey == nil {
+		if _, err := fs.Stat(m.fsys, path.Join(m.dir, SignatureFileName)); err == nil {
+			return fmt.Errorf("%w: %s", ErrSigningKeyRequired, m.pagePath())
+		}
+	}
+	return nil
+}
+
+// setPayloadCID records the payload root CID in the page's metadata, rewriting metadata.json if the page was read from
+// or written to a filesystem. The page is signed again if the Manifest has a signing key.
+func (m *Manifest) setPayloadCID(c cid.Cid) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.metadata.PayloadCID = c.String()
+	if m.fsys == nil {
+		return nil
+	}
+
+	fsys, ok := m.fsys.(WriteFS)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReadOnlyFS, m.pagePath())
+	}
+
+	lock, err := lockPageFS(fsys, m.dir)
+	if err != nil {
+		return err
+	}
+	defer func(lock *pageLock) {
+		if err := lock.unlock(); err != nil {
+			fmt.Println(fmt.Errorf("car_writer: %w", err))
+		}
+	}(lock)
+
+	if err := m.writeMetadataTo(fsys, m.dir); err != nil {
+		return err
+	}
+
+	if m.signingKey != nil {
+		return m.writeSignatureTo(fsys, m.dir)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
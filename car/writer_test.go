@@ -0,0 +1,144 @@
+package car
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testFile struct {
+	path string
+	size int64
+}
+
+// writeTestTree writes files of pseudo-random content to a new directory and returns the directory along with a page
+// listing the files. Each file is described by its path and size, and its content is seeded by its position.
+func writeTestTree(t *testing.T, files ...testFile) (string, *Manifest) {
+	t.Helper()
+
+	root := t.TempDir()
+	m := NewManifest("ns", 0)
+	for i, f := range files {
+		b := make([]byte, f.size)
+		if _, err := rand.NewChaCha8([32]byte{byte(i + 1)}).Read(b); err != nil {
+			t.Fatal(err)
+		}
+
+		p := filepath.Join(root, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m.Add(testEntry(f.path, "", f.size))
+	}
+	return root, m
+}
+
+// TestCarWriterCIDs compares the root CIDs of the CARs written for a page with the root CIDs computed for the same
+// files by the boxo UnixFS importer, which is used by graphsplit.
+func TestCarWriterCIDs(t *testing.T) {
+	root, m := writeTestTree(t,
+		testFile{path: "a.txt", size: 100},
+		testFile{path: "dir/b.bin", size: 50000},
+		testFile{path: "dir/sub/c.bin", size: 3000},
+		testFile{path: "empty", size: 0})
+
+	tests := []struct {
+		layout     DagLayout
+		rawLeaves  bool
+		cidVersion int
+		root       string
+	}{
+		{LayoutBalanced, false, 0, "QmWd3GMbK93bTtBeY61bCApJSWa18PY9RDZcHafkap3M7E"},
+		{LayoutBalanced, false, 1, "bafybeid6xwkx4t72ppm767jvjgvw6ozxazs4xh7rswwyw4pwqc4eqtxxre"},
+		{LayoutBalanced, true, 0, "QmQHnifzaG2rSEDQKXgNustAwz3Wt2NruL52kPWqUbPuvn"},
+		{LayoutBalanced, true, 1, "bafybeia3oo3wnh2dthxouiav4rk6p4bd2auizuy3fots27igs6qb7crdgm"},
+		{LayoutTrickle, false, 0, "QmRyszaNxpcc83orn9bfgVNnwXF6ii1BqryNc5BU9zVP3V"},
+		{LayoutTrickle, false, 1, "bafybeiefx2zx5ycew46ud3ak76gmlavwotdoymilyfpc5dackeoddb5zz4"},
+		{LayoutTrickle, true, 0, "QmQUMFX1vRpVsVENx32MFo2cx5t2k4dbUQNPNpKnHX7zM5"},
+		{LayoutTrickle, true, 1, "bafybeieddzmbkmubsk7duk3qix2byky6xijkkloylxdptquc5fmftl6tna"},
+	}
+
+	for _, tt := range tests {
+		for _, version := range []int{1, 2} {
+			w := &CarWriter{
+				Version:    version,
+				CIDVersion: tt.cidVersion,
+				ChunkSize:  1024,
+				MaxLinks:   4,
+				Layout:     tt.layout,
+				RawLeaves:  tt.rawLeaves,
+			}
+
+			c, err := w.Write(context.Background(), m, root, filepath.Join(t.TempDir(), "out.car"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.String() != tt.root {
+				t.Errorf("%s layout, raw leaves %t, CIDv%d, CARv%d: root %s, expected %s", tt.layout, tt.rawLeaves,
+					tt.cidVersion, version, c, tt.root)
+			}
+		}
+	}
+}
+
+func TestWriteSignedPage(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	m := NewManifest("ns", 0)
+	m.Add(testEntry("a.txt", "", 1))
+	m.SetSigningKey(private)
+	if err := m.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(filepath.Join(out, "00"), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "00.car")
+	if _, err := NewCarWriter().Write(context.Background(), read, root, dst); !errors.Is(err, ErrSigningKeyRequired) {
+		t.Fatalf("expected ErrSigningKeyRequired, got %v", err)
+	}
+
+	if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no CAR to be written, got %v", err)
+	}
+
+	if _, err := Read(filepath.Join(out, "00"), public); err != nil {
+		t.Fatalf("expected the page to remain readable: %v", err)
+	}
+
+	read.SetSigningKey(private)
+	c, err := NewCarWriter().Write(context.Background(), read, root, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := Read(filepath.Join(out, "00"), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signed.PayloadCID() != c.String() {
+		t.Errorf("payload CID %s, expected %s", signed.PayloadCID(), c)
+	}
+}
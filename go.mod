@@ -6,9 +6,10 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/ipfs/go-cid v0.5.0
 	github.com/json-iterator/go v1.1.12
-	github.com/libp2p/go-buffer-pool v0.1.0
 	github.com/minio/sha256-simd v1.0.1
+	github.com/multiformats/go-multihash v0.2.3
 	github.com/transientvariable/anchor v0.0.0-20250331040147-31a7b773ebd9
+	golang.org/x/sys v0.31.0
 )
 
 require (
@@ -19,11 +20,9 @@ require (
 	github.com/multiformats/go-base32 v0.1.0 // indirect
 	github.com/multiformats/go-base36 v0.2.0 // indirect
 	github.com/multiformats/go-multibase v0.2.0 // indirect
-	github.com/multiformats/go-multihash v0.2.3 // indirect
 	github.com/multiformats/go-varint v0.0.7 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	golang.org/x/crypto v0.36.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	lukechampine.com/blake3 v1.4.0 // indirect
 )